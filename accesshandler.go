@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//GrantListResponce は閲覧許可一覧取得レスポンス構造体
+type GrantListResponce struct {
+	Count  int                      `json:"count" xml:"count"`
+	Grants []GrantListGrantResponce `json:"grants" xml:"grants"`
+}
+
+//GrantListGrantResponce は閲覧許可一覧取得レスポンスの閲覧許可情報を保持する
+type GrantListGrantResponce struct {
+	ID        int64  `json:"id" xml:"id"`
+	Hash      string `json:"hash" xml:"hash"`
+	Path      string `json:"path" xml:"path"`
+	UserName  string `json:"username" xml:"username"`
+	AuthLevel int    `json:"authlevel" xml:"authlevel"`
+}
+
+//GrantAddRequest は閲覧許可追加リクエストデータ構造体
+type GrantAddRequest struct {
+	Hash      string `json:"hash" xml:"hash" form:"hash" query:"hash"`
+	UserName  string `json:"username" xml:"username" form:"username" query:"username"`
+	AuthLevel int    `json:"authlevel" xml:"authlevel" form:"authlevel" query:"authlevel"`
+}
+
+//GrantDeleteRequest は閲覧許可削除リクエストデータ構造体
+type GrantDeleteRequest struct {
+	ID int64 `json:"id" xml:"id" form:"id" query:"id"`
+}
+
+//GrantResponce は閲覧許可追加・削除レスポンス構造体
+type GrantResponce struct {
+	Status int `json:"status" xml:"status"`
+}
+
+//GrantListHandler はフォルダの閲覧許可の一覧を返す。取得するには管理者権限が必要
+func GrantListHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	grantList, err := db.SelectFolderGrantAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	grantResponceList := make([]GrantListGrantResponce, 0)
+	for _, grant := range grantList {
+		folder, err := db.SelectFolderFromHash(ctx, grant.FolderHash)
+		if err != nil {
+			return err
+		}
+		grantResponceList = append(grantResponceList, GrantListGrantResponce{
+			ID:        grant.ID,
+			Hash:      grant.FolderHash,
+			Path:      folder.FilePath, //フォルダがなくなっている時は空
+			UserName:  grant.UserName,
+			AuthLevel: grant.Permission,
+		})
+	}
+
+	res := new(GrantListResponce)
+	res.Count = len(grantResponceList)
+	res.Grants = grantResponceList
+	return c.JSON(http.StatusOK, res)
+}
+
+//GrantAddHandler は指定したユーザーまたは権限レベルにフォルダの閲覧を許可する。追加するには管理者権限が必要
+//許可したフォルダ以下のフォルダ・アーカイブも閲覧できる
+func GrantAddHandler(c echo.Context) error {
+	req := new(GrantAddRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("GrantAddHandler request=%v\n", *req)
+
+	if (req.UserName == "") == (req.AuthLevel == 0) {
+		return echo.NewHTTPError(http.StatusBadRequest, "ユーザー名と権限レベルのどちらか一方を指定する")
+	}
+	ctx := c.Request().Context()
+	folder, err := db.SelectFolderFromHash(ctx, req.Hash)
+	if err != nil || folder.Hash == "" {
+		return echo.NewHTTPError(http.StatusNotFound, "指定したフォルダが見つからない")
+	}
+	if req.UserName != "" {
+		user, err := db.SelectUser(ctx, req.UserName)
+		if err != nil || user.ID == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "指定したユーザーが見つからない")
+		}
+	}
+
+	err = db.InsertFolderGrant(ctx, folder.Hash, req.UserName, req.AuthLevel)
+	if err != nil {
+		return err
+	}
+
+	res := new(GrantResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//GrantDeleteHandler は閲覧許可を削除する。削除するには管理者権限が必要
+func GrantDeleteHandler(c echo.Context) error {
+	req := new(GrantDeleteRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("GrantDeleteHandler request=%v\n", *req)
+
+	err := db.DeleteFolderGrant(c.Request().Context(), req.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	res := new(GrantResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//RatingListResponce はフォルダ対象年齢一覧取得レスポンス構造体
+type RatingListResponce struct {
+	Count   int                        `json:"count" xml:"count"`
+	Ratings []RatingListRatingResponce `json:"ratings" xml:"ratings"`
+}
+
+//RatingListRatingResponce はフォルダ対象年齢一覧取得レスポンスの対象年齢情報を保持する
+type RatingListRatingResponce struct {
+	Hash      string `json:"hash" xml:"hash"`
+	Path      string `json:"path" xml:"path"`
+	AgeRating int    `json:"agerating" xml:"agerating"`
+}
+
+//RatingSetRequest はフォルダ対象年齢設定リクエストデータ構造体
+type RatingSetRequest struct {
+	Hash      string `json:"hash" xml:"hash" form:"hash" query:"hash"`
+	AgeRating int    `json:"agerating" xml:"agerating" form:"agerating" query:"agerating"`
+}
+
+//RatingSetResponce はフォルダ対象年齢設定レスポンス構造体
+type RatingSetResponce struct {
+	Status int `json:"status" xml:"status"`
+}
+
+//RatingListHandler はフォルダに設定した対象年齢の一覧を返す。取得するには管理者権限が必要
+func RatingListHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	ratingList, err := db.SelectFolderRatingAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	ratingResponceList := make([]RatingListRatingResponce, 0)
+	for _, rating := range ratingList {
+		folder, err := db.SelectFolderFromHash(ctx, rating.FolderHash)
+		if err != nil {
+			return err
+		}
+		ratingResponceList = append(ratingResponceList, RatingListRatingResponce{
+			Hash:      rating.FolderHash,
+			Path:      folder.FilePath, //フォルダがなくなっている時は空
+			AgeRating: rating.AgeRating,
+		})
+	}
+
+	res := new(RatingListResponce)
+	res.Count = len(ratingResponceList)
+	res.Ratings = ratingResponceList
+	return c.JSON(http.StatusOK, res)
+}
+
+//RatingSetHandler はフォルダの対象年齢を設定する（0の時は設定を削除する）。設定するには管理者権限が必要
+//設定したフォルダ以下のすべてのアーカイブに適用する
+func RatingSetHandler(c echo.Context) error {
+	req := new(RatingSetRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("RatingSetHandler request=%v\n", *req)
+
+	if req.AgeRating < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "対象年齢が不正")
+	}
+	ctx := c.Request().Context()
+	folder, err := db.SelectFolderFromHash(ctx, req.Hash)
+	if err != nil || folder.Hash == "" {
+		return echo.NewHTTPError(http.StatusNotFound, "指定したフォルダが見つからない")
+	}
+
+	err = db.SetFolderRating(ctx, folder.Hash, req.AgeRating)
+	if err != nil {
+		return err
+	}
+
+	res := new(RatingSetResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//ScanStartRequest はライブラリ探索開始リクエストデータ構造体
+type ScanStartRequest struct {
+	Hash  string `json:"hash" xml:"hash" form:"hash" query:"hash"`
+	Full  bool   `json:"full" xml:"full" form:"full" query:"full"`
+	Force bool   `json:"force" xml:"force" form:"force" query:"force"`
+}
+
+//ScanStartResponce はライブラリ探索開始・中止レスポンスデータ構造体
+type ScanStartResponce struct {
+	Status int `json:"status" xml:"status"`
+}
+
+//ScanStatusResponce はライブラリ探索の進捗状況レスポンスデータ構造体
+type ScanStatusResponce struct {
+	Running     bool      `json:"running" xml:"running"`
+	Cancelled   bool      `json:"cancelled" xml:"cancelled"`
+	Path        string    `json:"path" xml:"path"`
+	Full        bool      `json:"full" xml:"full"`
+	StartTime   time.Time `json:"starttime" xml:"starttime"`
+	EndTime     time.Time `json:"endtime" xml:"endtime"`
+	DurationSec int       `json:"durationsec" xml:"durationsec"`
+	Seen        int       `json:"seen" xml:"seen"`
+	Queued      int       `json:"queued" xml:"queued"`
+	Processed   int       `json:"processed" xml:"processed"`
+	Added       int       `json:"added" xml:"added"`
+	Updated     int       `json:"updated" xml:"updated"`
+	Moved       int       `json:"moved" xml:"moved"`
+	Restored    int       `json:"restored" xml:"restored"`
+	Removed     int       `json:"removed" xml:"removed"`
+	Errors      int       `json:"errors" xml:"errors"`
+	LastError   string    `json:"lasterror" xml:"lasterror"`
+	EtaSec      int       `json:"etasec" xml:"etasec"`
+}
+
+//ProblemListResponce は問題のあるアーカイブ一覧取得レスポンスデータ構造体
+type ProblemListResponce struct {
+	Count int                       `json:"count" xml:"count"`
+	Books []ProblemListBookResponce `json:"books" xml:"books"`
+}
+
+//ProblemListBookResponce は問題のあるアーカイブ一覧取得レスポンスのアーカイブ情報を保持する
+type ProblemListBookResponce struct {
+	Hash         string    `json:"hash" xml:"hash"`
+	Path         string    `json:"path" xml:"path"`
+	Size         int       `json:"size" xml:"size"`
+	ModTime      time.Time `json:"modtime" xml:"modtime"`
+	ErrorMessage string    `json:"error" xml:"error"`
+}
+
+//DuplicateListRequest は重複アーカイブ一覧取得リクエストデータ構造体
+type DuplicateListRequest struct {
+	Distance int `json:"distance" xml:"distance" form:"distance" query:"distance"`
+}
+
+//DuplicateListResponce は重複アーカイブ一覧取得レスポンスデータ構造体
+type DuplicateListResponce struct {
+	Count  int                          `json:"count" xml:"count"`
+	Groups []DuplicateListGroupResponce `json:"groups" xml:"groups"`
+}
+
+//DuplicateListGroupResponce は重複アーカイブ一覧取得レスポンスのグループ情報を保持する
+type DuplicateListGroupResponce struct {
+	Reason string                      `json:"reason" xml:"reason"`
+	Books  []DuplicateListBookResponce `json:"books" xml:"books"`
+}
+
+//DuplicateListBookResponce は重複アーカイブ一覧取得レスポンスのアーカイブ情報を保持する
+type DuplicateListBookResponce struct {
+	Hash    string    `json:"hash" xml:"hash"`
+	Path    string    `json:"path" xml:"path"`
+	Size    int       `json:"size" xml:"size"`
+	Page    int       `json:"page" xml:"page"`
+	ModTime time.Time `json:"modtime" xml:"modtime"`
+}
+
+//ScanStartHandler はライブラリ探索を開始する。開始するには管理者権限が必要
+//フォルダのハッシュを指定した時はそのフォルダ以下だけを探索する
+//forceを指定した時は見つからなくなったアーカイブが多くても削除する（ディスクを入れ替えた時など）
+func ScanStartHandler(c echo.Context) error {
+	req := new(ScanStartRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("ScanStartHandler request=%v\n", *req)
+
+	basePath := ""
+	if req.Hash != "" {
+		folder, err := db.SelectFolderFromHash(c.Request().Context(), req.Hash)
+		if err != nil || folder.Hash == "" {
+			return echo.NewHTTPError(http.StatusNotFound, "指定したフォルダが見つからない")
+		}
+		basePath = folder.FilePath
+	}
+	if err := NewFileWatcher().StartScan(basePath, req.Full, req.Force); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+
+	res := new(ScanStartResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//ScanCancelHandler は実行中のライブラリ探索を中止する。中止するには管理者権限が必要
+func ScanCancelHandler(c echo.Context) error {
+	if !NewFileWatcher().CancelScan() {
+		return echo.NewHTTPError(http.StatusConflict, "探索中ではない")
+	}
+
+	res := new(ScanStartResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//ScanStatusHandler はライブラリ探索の進捗状況を返す。取得するには管理者権限が必要
+func ScanStatusHandler(c echo.Context) error {
+	status := scanProgress.Status()
+	res := new(ScanStatusResponce)
+	res.Running = status.Running
+	res.Cancelled = status.Cancelled
+	res.Path = status.Path
+	res.Full = status.Full
+	res.StartTime = status.StartTime
+	res.EndTime = status.EndTime
+	res.DurationSec = int(status.Duration().Seconds())
+	res.Seen = status.SeenCount
+	res.Queued = status.QueuedCount
+	res.Processed = status.ProcessedCount
+	res.Added = status.AddedCount
+	res.Updated = status.UpdatedCount
+	res.Moved = status.MovedCount
+	res.Restored = status.RestoredCount
+	res.Removed = status.RemovedCount
+	res.Errors = status.ErrorCount
+	res.LastError = status.LastError
+	res.EtaSec = int(status.EstimateRemaining().Seconds())
+	return c.JSON(http.StatusOK, res)
+}
+
+//ProblemListHandler は開けない・ページ画像を読み込めないアーカイブの一覧を返す。取得するには管理者権限が必要
+func ProblemListHandler(c echo.Context) error {
+	bookList, err := db.SelectBookListFromStatus(c.Request().Context(), db.BookStatusBroken)
+	if err != nil {
+		return err
+	}
+	bookList = db.ExcludeDeletedBook(bookList)
+
+	res := new(ProblemListResponce)
+	res.Count = len(bookList)
+	bookResponceList := make([]ProblemListBookResponce, 0)
+	for _, book := range bookList {
+		bookResponceList = append(bookResponceList, ProblemListBookResponce{
+			Hash:         book.Hash,
+			Path:         book.FilePath,
+			Size:         book.FileSize,
+			ModTime:      book.ModTime.UTC(),
+			ErrorMessage: book.ErrorMessage,
+		})
+	}
+	res.Books = bookResponceList
+	return c.JSON(http.StatusOK, res)
+}
+
+//DuplicateListHandler は重複している可能性があるアーカイブをグループにして返す。取得するには管理者権限が必要
+func DuplicateListHandler(c echo.Context) error {
+	req := new(DuplicateListRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("DuplicateListHandler request=%v\n", *req)
+
+	bookList, err := db.SelectBookAll(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	distance := req.Distance
+	if distance <= 0 {
+		distance = config.GetConfig().File.DuplicateMaxDistance
+	}
+
+	groupResponceList := make([]DuplicateListGroupResponce, 0)
+	for _, group := range findDuplicateGroups(bookList, distance) {
+		bookResponceList := make([]DuplicateListBookResponce, 0)
+		for _, book := range group.BookList {
+			bookResponceList = append(bookResponceList, DuplicateListBookResponce{
+				Hash:    book.Hash,
+				Path:    book.FilePath,
+				Size:    book.FileSize,
+				Page:    book.Page,
+				ModTime: book.ModTime.UTC(),
+			})
+		}
+		groupResponceList = append(groupResponceList, DuplicateListGroupResponce{
+			Reason: group.Reason,
+			Books:  bookResponceList,
+		})
+	}
+
+	res := new(DuplicateListResponce)
+	res.Count = len(groupResponceList)
+	res.Groups = groupResponceList
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//APIKeyListRequest はAPIキー一覧取得リクエストデータ構造体
+type APIKeyListRequest struct {
+	UserName string `json:"username" xml:"username" form:"username" query:"username"`
+	All      bool   `json:"all" xml:"all" form:"all" query:"all"`
+}
+
+//APIKeyListResponce はAPIキー一覧取得レスポンス構造体
+type APIKeyListResponce struct {
+	Count   int                        `json:"count" xml:"count"`
+	APIKeys []APIKeyListAPIKeyResponce `json:"apikeys" xml:"apikeys"`
+}
+
+//APIKeyListAPIKeyResponce はAPIキー一覧取得レスポンスのAPIキー情報を保持する（キーそのものは返さない）
+type APIKeyListAPIKeyResponce struct {
+	ID        int64     `json:"id" xml:"id"`
+	UserName  string    `json:"username" xml:"username"`
+	Name      string    `json:"name" xml:"name"`
+	Prefix    string    `json:"prefix" xml:"prefix"`
+	Scope     string    `json:"scope" xml:"scope"`
+	ExpiresAt time.Time `json:"expiresat" xml:"expiresat"`
+	Expired   bool      `json:"expired" xml:"expired"`
+	CreatedAt time.Time `json:"createdat" xml:"createdat"`
+}
+
+//APIKeyCreateRequest はAPIキー作成リクエストデータ構造体
+type APIKeyCreateRequest struct {
+	Name       string `json:"name" xml:"name" form:"name" query:"name"`
+	Scope      string `json:"scope" xml:"scope" form:"scope" query:"scope"`
+	ExpireDays int    `json:"expiredays" xml:"expiredays" form:"expiredays" query:"expiredays"`
+}
+
+//APIKeyCreateResponce はAPIキー作成レスポンス構造体
+type APIKeyCreateResponce struct {
+	Key       string    `json:"key" xml:"key"`
+	Name      string    `json:"name" xml:"name"`
+	Scope     string    `json:"scope" xml:"scope"`
+	ExpiresAt time.Time `json:"expiresat" xml:"expiresat"`
+}
+
+//APIKeyDeleteRequest はAPIキー削除リクエストデータ構造体
+type APIKeyDeleteRequest struct {
+	ID int64 `json:"id" xml:"id" form:"id" query:"id"`
+}
+
+//APIKeyDeleteResponce はAPIキー削除レスポンス構造体
+type APIKeyDeleteResponce struct {
+	Status int `json:"status" xml:"status"`
+}
+
+//APIKeyListHandler はログインユーザーのAPIキーの一覧を返す
+//他のユーザーまたはすべてのユーザーのAPIキーを取得するには管理者権限が必要
+func APIKeyListHandler(c echo.Context) error {
+	req := new(APIKeyListRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	loginUser := NewLoginUserFromRequest(c)
+	userName := loginUser.UserName
+	if req.UserName != "" {
+		userName = req.UserName
+	}
+	if (req.All || userName != loginUser.UserName) && loginUser.AuthLevel < db.UserPermissionAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "権限がない")
+	}
+
+	ctx := c.Request().Context()
+	var apiKeyList []db.APIKeyTable
+	var err error
+	if req.All {
+		apiKeyList, err = db.SelectAPIKeyAll(ctx)
+	} else {
+		apiKeyList, err = db.SelectAPIKeyListFromUser(ctx, userName)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	apiKeyResponceList := make([]APIKeyListAPIKeyResponce, 0)
+	for _, apiKey := range apiKeyList {
+		apiKeyResponceList = append(apiKeyResponceList, APIKeyListAPIKeyResponce{
+			ID:        apiKey.ID,
+			UserName:  apiKey.UserName,
+			Name:      apiKey.Name,
+			Prefix:    apiKey.KeyPrefix,
+			Scope:     apiKey.Scope,
+			ExpiresAt: apiKey.ExpiresAt.UTC(),
+			Expired:   apiKey.IsExpired(now),
+			CreatedAt: apiKey.CreatedAt.UTC(),
+		})
+	}
+
+	res := new(APIKeyListResponce)
+	res.Count = len(apiKeyResponceList)
+	res.APIKeys = apiKeyResponceList
+	return c.JSON(http.StatusOK, res)
+}
+
+//APIKeyCreateHandler はログインユーザーのAPIキーを作成して返す（キーはこのレスポンスでしか取得できない）
+//APIキーで新しいAPIキーは作成できない（パスワードでログインしたトークンが必要）
+func APIKeyCreateHandler(c echo.Context) error {
+	req := new(APIKeyCreateRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("APIKeyCreateHandler request=%v\n", *req)
+
+	loginUser := NewLoginUserFromRequest(c)
+	if loginUser.APIKeyScope != "" {
+		return echo.NewHTTPError(http.StatusForbidden, "APIキーではAPIキーを作成できない")
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "名前が未入力")
+	}
+	if !db.IsValidAPIKeyScope(req.Scope) {
+		return echo.NewHTTPError(http.StatusBadRequest, "権限範囲が不正")
+	}
+	if req.ExpireDays < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "有効期限が不正")
+	}
+
+	expiresAt := db.APIKeyNoExpire
+	if req.ExpireDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, req.ExpireDays)
+	}
+	key, err := db.CreateAPIKey(c.Request().Context(), loginUser.UserName, req.Name, req.Scope, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	res := new(APIKeyCreateResponce)
+	res.Key = key
+	res.Name = req.Name
+	res.Scope = req.Scope
+	res.ExpiresAt = expiresAt.UTC()
+	return c.JSON(http.StatusOK, res)
+}
+
+//APIKeyDeleteHandler はAPIキーを削除する。他のユーザーのAPIキーを削除するには管理者権限が必要
+func APIKeyDeleteHandler(c echo.Context) error {
+	req := new(APIKeyDeleteRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("APIKeyDeleteHandler request=%v\n", *req)
+
+	loginUser := NewLoginUserFromRequest(c)
+	ctx := c.Request().Context()
+	apiKey, err := db.SelectAPIKeyFromID(ctx, req.ID)
+	if err != nil {
+		return err
+	}
+	//他のユーザーのAPIキーがあるかどうかは管理者以外には返さない
+	if apiKey.ID == 0 || (apiKey.UserName != loginUser.UserName && loginUser.AuthLevel < db.UserPermissionAdmin) {
+		return echo.NewHTTPError(http.StatusNotFound, "指定したAPIキーが見つからない")
+	}
+
+	err = db.DeleteAPIKey(ctx, apiKey.ID)
+	if err != nil {
+		return err
+	}
+
+	res := new(APIKeyDeleteResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
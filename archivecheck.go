@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//archiveInfo はアーカイブの確認で取得した情報を保持する
+type archiveInfo struct {
+	Page      int
+	CoverHash string //表紙画像の知覚ハッシュ
+	AgeRating int    //ComicInfo.xmlの対象年齢（開けない時はdb.AgeRatingUnread）
+}
+
+//validateArchive はアーカイブを開いてファイル一覧と先頭ページの画像を読み込めるか確認し、ページ数・表紙画像の知覚ハッシュ・対象年齢を返す
+func validateArchive(filePath string) (archiveInfo, error) {
+	info := archiveInfo{AgeRating: db.AgeRatingUnread}
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return info, fmt.Errorf("アーカイブを開けない: %s", trimPathError(err))
+	}
+	defer r.Close()
+	fileList := pageFileList(&r.Reader)
+	info.Page = len(fileList)
+	info.AgeRating = readComicInfoAgeRating(&r.Reader)
+
+	//先頭ページ（サムネイルに使用するファイル）の画像を読み込む
+	for _, f := range fileList {
+		rc, err := f.Open()
+		if err != nil {
+			return info, fmt.Errorf("先頭ページを開けない name=%s: %s", f.Name, err)
+		}
+		defer rc.Close()
+		img, _, err := image.Decode(rc)
+		if err != nil {
+			return info, fmt.Errorf("先頭ページの画像を読み込めない name=%s: %s", f.Name, err)
+		}
+		info.CoverHash = createCoverHash(img)
+		return info, nil
+	}
+	return info, fmt.Errorf("ページがない")
+}
+
+//createBookStatus はアーカイブの確認結果からDBに保存する状態とエラー内容を返す
+func createBookStatus(err error) (int, string) {
+	if err != nil {
+		return db.BookStatusBroken, err.Error()
+	}
+	return db.BookStatusOK, ""
+}
+
+//trimPathError はファイル操作のエラーからファイルパスを取り除く（ファイルパスは別に保持しているため）
+func trimPathError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
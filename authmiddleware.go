@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//loginUserContextKey はDBから取得したログイン情報をechoのコンテキストに保存するキー
+const loginUserContextKey = "loginUser"
+
+//apiKeyHeaderName はトークンの代わりにAPIキーを指定するヘッダー名
+const apiKeyHeaderName = "X-API-Key"
+
+//passwordChangeAllowPathMap はパスワードの変更が必要なユーザーでも使用できるAPI
+var passwordChangeAllowPathMap = map[string]bool{
+	"/api/changepassword": true,
+	"/api/logout":         true,
+	"/api/logoutall":      true,
+}
+
+//NewJWTMiddleware はトークンを確認するミドルウェアを返す
+//トークンがない・不正な時は401エラーを返す。APIキーのヘッダーがある時はトークンを確認しない
+func NewJWTMiddleware() echo.MiddlewareFunc {
+	return middleware.JWTWithConfig(middleware.JWTConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.Request().Header.Get(apiKeyHeaderName) != ""
+		},
+		SigningKey: []byte(config.GetConfig().Login.TokenSalt),
+		ErrorHandler: func(err error) error {
+			return echo.NewHTTPError(http.StatusUnauthorized, "ログインが必要")
+		},
+	})
+}
+
+//LoadLoginUserMiddleware はトークンまたはAPIキーのユーザーをDBから取得してログイン情報としてコンテキストに保存する
+//権限などはトークンの内容ではなくDBの値を使用し、ユーザーが削除されている時（同じ名前で作成し直された時を含む）やトークンのバージョンが古い時は401エラーを返す
+//APIキーが見つからない・有効期限切れの時も401エラーを返す
+func LoadLoginUserMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if key := c.Request().Header.Get(apiKeyHeaderName); key != "" {
+			loginUser, err := NewLoginUserFromAPIKey(c.Request().Context(), key)
+			if err != nil {
+				fmt.Printf("LoadLoginUserMiddleware api key err=%s\n", err)
+				return echo.NewHTTPError(http.StatusUnauthorized, "APIキーが無効")
+			}
+			c.Set(loginUserContextKey, loginUser)
+			return next(c)
+		}
+
+		tokenUser, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "ログインが必要")
+		}
+		claims := tokenUser.Claims.(jwt.MapClaims)
+		userName, _ := claims["name"].(string)
+		userID, _ := claims["uid"].(string)
+		version, _ := claims["ver"].(string)
+
+		user, err := db.SelectUser(c.Request().Context(), userName)
+		if err != nil || user.ID == 0 || strconv.FormatInt(user.ID, 10) != userID || strconv.Itoa(user.TokenVersion) != version {
+			return echo.NewHTTPError(http.StatusUnauthorized, "トークンが無効")
+		}
+		c.Set(loginUserContextKey, newLoginUserFromTable(user))
+		return next(c)
+	}
+}
+
+//PasswordChangeMiddleware はパスワードの変更が必要なユーザーのリクエストを、パスワード変更・ログアウト以外は403エラーで拒否する
+func PasswordChangeMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		loginUser := NewLoginUserFromRequest(c)
+		if loginUser.MustChangePassword && !passwordChangeAllowPathMap[c.Path()] {
+			return echo.NewHTTPError(http.StatusForbidden, "パスワードの変更が必要")
+		}
+		return next(c)
+	}
+}
+
+//RequirePermissionMiddleware は指定した権限レベル以上のユーザーだけを許可するミドルウェアを返す
+//権限が足りない時は403エラーを返す
+func RequirePermissionMiddleware(permission int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			loginUser := NewLoginUserFromRequest(c)
+			if loginUser.AuthLevel < permission {
+				return echo.NewHTTPError(http.StatusForbidden, "権限がない")
+			}
+			return next(c)
+		}
+	}
+}
+
+//RequireWriteScopeMiddleware は読み取り専用のAPIキーからのリクエストを403エラーで拒否する
+//データを変更するAPIに設定する（トークンでログインしている時は常に許可する）
+func RequireWriteScopeMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		loginUser := NewLoginUserFromRequest(c)
+		if loginUser.APIKeyScope == db.APIKeyScopeRead {
+			return echo.NewHTTPError(http.StatusForbidden, "APIキーに書き込み権限がない")
+		}
+		return next(c)
+	}
+}
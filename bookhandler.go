@@ -29,10 +29,15 @@ func SaveBookHandler(c echo.Context) error {
 	fmt.Printf("SaveBookHandler request=%v\n", *req)
 
 	//トークンからユーザー名を取得
+	ctx := c.Request().Context()
 	loginUser := NewLoginUserFromRequest(c)
+	err := checkBookAccess(ctx, loginUser, req.Hash)
+	if err != nil {
+		return err
+	}
 
 	//データの追加
-	err := db.InsertHistory(loginUser.UserName, req.Hash, req.Index, req.Reqction, true)
+	err = db.InsertHistory(ctx, loginUser.UserName, req.Hash, req.Index, req.Reqction, true)
 	if err != nil {
 		return err
 	}
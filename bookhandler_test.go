@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//requestSaveBook は読み込み位置を保存してステータスコードを返す
+func requestSaveBook(t *testing.T, userName string, bookPath string, index string) int {
+	form := url.Values{}
+	form.Set("hash", testHash(t, bookPath))
+	form.Set("index", index)
+	form.Set("reaction", "1")
+	c, rec := newTestContext(http.MethodPost, "/api/savebook", form, newTestLoginUser(t, userName))
+	return responseCode(SaveBookHandler(c), rec)
+}
+
+func TestSaveBookHandler(t *testing.T) {
+	if code := requestSaveBook(t, testUser, testOpenBook, "2"); code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	history, err := db.SelectHistory(context.Background(), testUser, testHash(t, testOpenBook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.ReadPos != 2 || history.Reaction != 1 {
+		t.Errorf("history=%v", history)
+	}
+}
+
+func TestSaveBookHandlerForbidden(t *testing.T) {
+	if code := requestSaveBook(t, testGuestUser, testSecretBook, "1"); code != http.StatusForbidden {
+		t.Errorf("guest code=%d", code)
+	}
+	if code := requestSaveBook(t, testKidUser, testTeenBook, "1"); code != http.StatusForbidden {
+		t.Errorf("kid code=%d", code)
+	}
+}
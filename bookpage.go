@@ -2,9 +2,12 @@ package main
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,17 +24,22 @@ type BookPage struct {
 var (
 	unzipMutex     *sync.Mutex
 	unzipBusyParam string
+	pageImageExt   = []string{".jpg", ".jpeg", ".png", ".gif"} //ページとして扱う画像ファイルの拡張子
 )
 
 //NewBookPage は書庫ページ情報を生成する
 //引数のhash, filePathはいずれかが空文字でも可能となりその場合は内部で取得して設定する
-func NewBookPage(hash string, filePath string) *BookPage {
+func NewBookPage(ctx context.Context, hash string, filePath string) *BookPage {
 	bookPage := new(BookPage)
 	if hash == "" {
-		hash = db.CreateBookHash(filePath)
+		bookRecord, err := db.SelectBook(ctx, filePath)
+		if err != nil {
+			return nil
+		}
+		hash = bookRecord.Hash
 	}
 	if filePath == "" {
-		bookRecord, err := db.SelectBookFromHash(hash)
+		bookRecord, err := db.SelectBookFromHash(ctx, hash)
 		if err != nil {
 			return nil
 		}
@@ -51,7 +59,7 @@ func (bookPage *BookPage) GetPageCount() (int, error) {
 	}
 	defer r.Close()
 
-	count := len(r.File)
+	count := len(pageFileList(&r.Reader))
 	return count, nil
 }
 
@@ -72,13 +80,11 @@ func (bookPage *BookPage) CreatePageFile(index int, maxHeight uint, maxWidth uin
 	defer r.Close()
 
 	//ページのファイルを検索
-	if len(r.File) < index {
+	fileList := pageFileList(&r.Reader)
+	if index < 0 || index >= len(fileList) {
 		return "", fmt.Errorf("対象ページなし")
 	}
-	zipFile := r.File[index]
-	if zipFile.FileInfo().IsDir() {
-		return "", fmt.Errorf("対象ページがフォルダ")
-	}
+	zipFile := fileList[index]
 
 	//ページ画像ファイル取得
 	rc, err := zipFile.Open()
@@ -160,13 +166,10 @@ func (bookPage *BookPage) UnzipPageFile(index int, limit int, maxHeight uint, ma
 	defer r.Close()
 
 	count := 0
-	for i, imageFile := range r.File {
+	for i, imageFile := range pageFileList(&r.Reader) {
 		if i < index || i >= (index+limit) {
 			continue
 		}
-		if imageFile.FileInfo().IsDir() {
-			continue
-		}
 		resize := NewResize(maxHeight, maxWidth, config.GetConfig().File.PageJpegQuality)
 
 		//既にファイルがあるかどうか確認
@@ -197,3 +200,35 @@ func (bookPage *BookPage) UnzipPageFile(index int, limit int, maxHeight uint, ma
 	fmt.Printf("UnzipPageFile finish count=%d time=%f\n", count, (end.Sub(start)).Seconds())
 	return count, nil
 }
+
+//removePageCache は指定したアーカイブのページ画像キャッシュをすべて削除する（ページ位置が変わる時に使用する）
+func removePageCache(hash string) {
+	dirPath := filepath.Join(config.GetConfig().File.PageDirPath, hash)
+	if err := os.RemoveAll(dirPath); err != nil {
+		fmt.Printf("removePageCache RemoveAll err=%s\n", err)
+	}
+}
+
+//pageFileList はZIPファイル内のページ画像ファイルの一覧を返す（フォルダ・ComicInfo.xmlなど画像以外のファイルは含まない）
+//ページ位置はこの一覧の位置とする
+func pageFileList(r *zip.Reader) []*zip.File {
+	fileList := make([]*zip.File, 0)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isPageImageFile(f.Name) {
+			continue
+		}
+		fileList = append(fileList, f)
+	}
+	return fileList
+}
+
+//isPageImageFile はページとして扱う画像ファイルかどうかを拡張子で判定する
+func isPageImageFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, imageExt := range pageImageExt {
+		if ext == imageExt {
+			return true
+		}
+	}
+	return false
+}
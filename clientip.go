@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+//clientIP はリクエストの接続元IPアドレスを返す
+//X-Forwarded-For・X-Real-IPヘッダはクライアントが自由に指定できるため、接続元が信頼するプロキシの時だけ使用する
+func clientIP(c echo.Context) string {
+	req := c.Request()
+	ip := remoteIP(req)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	//X-Forwarded-Forは経由したプロキシが右に追加していくため、右から見て信頼するプロキシ以外の最初のIPアドレスを使用する
+	if forwarded := strings.Join(req.Header[echo.HeaderXForwardedFor], ","); forwarded != "" {
+		forwardedList := strings.Split(forwarded, ",")
+		for i := len(forwardedList) - 1; i >= 0; i-- {
+			forwardedIP := strings.TrimSpace(forwardedList[i])
+			if net.ParseIP(forwardedIP) == nil {
+				break
+			}
+			ip = forwardedIP
+			if !isTrustedProxy(forwardedIP) {
+				return forwardedIP
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(req.Header.Get(echo.HeaderXRealIP)); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+//remoteIP はリクエストの接続元（直接接続している相手）のIPアドレスを返す
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+//isTrustedProxy は指定したIPアドレスが設定ファイルで信頼するプロキシとして指定されているかどうかを返す
+func isTrustedProxy(ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, proxy := range config.GetConfig().Server.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			if _, ipNet, err := net.ParseCIDR(proxy); err == nil && ipNet.Contains(parsedIP) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//comicInfoFileName はアーカイブの情報を記載するファイル名（ComicRack形式）
+const comicInfoFileName = "comicinfo.xml"
+
+//comicInfo はComicInfo.xmlの内容のうち使用する項目を保持する
+type comicInfo struct {
+	AgeRating string `xml:"AgeRating"`
+}
+
+//comicInfoAgeRatingMap はComicInfo.xmlのAgeRatingの値と対象年齢の対応（キーは小文字）
+var comicInfoAgeRatingMap = map[string]int{
+	"unknown":         0,
+	"rating pending":  0,
+	"everyone":        0,
+	"g":               0,
+	"early childhood": 3,
+	"kids to adults":  6,
+	"everyone 10+":    10,
+	"pg":              10,
+	"teen":            13,
+	"ma15+":           15,
+	"m":               17,
+	"mature 17+":      17,
+	"r18+":            18,
+	"adults only 18+": 18,
+	"x18+":            18,
+}
+
+//ageRatingNumberRegexp は対応表にないAgeRatingの値から年齢を取り出す（"12+" など）
+var ageRatingNumberRegexp = regexp.MustCompile(`\d+`)
+
+//readComicInfoAgeRating はアーカイブ内のComicInfo.xmlから対象年齢を読み込む（ない・読み込めない時は0）
+func readComicInfoAgeRating(r *zip.Reader) int {
+	for _, f := range r.File {
+		if strings.ToLower(path.Base(f.Name)) != comicInfoFileName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return 0
+		}
+		defer rc.Close()
+
+		var info comicInfo
+		if err := xml.NewDecoder(rc).Decode(&info); err != nil {
+			return 0
+		}
+		return parseAgeRating(info.AgeRating)
+	}
+	return 0
+}
+
+//parseAgeRating はAgeRatingの値を対象年齢に変換する
+func parseAgeRating(value string) int {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if rating, ok := comicInfoAgeRatingMap[value]; ok {
+		return rating
+	}
+	rating, err := strconv.Atoi(ageRatingNumberRegexp.FindString(value))
+	if err != nil {
+		return 0
+	}
+	return rating
+}
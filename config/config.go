@@ -8,13 +8,17 @@ import (
 
 const settingFileName = "config.toml"
 
+//secretLogValue は設定をログに出力する時にパスワードなどの代わりに出力する値
+const secretLogValue = "********"
+
 //EnvConfig 環境設定構造体
 type EnvConfig struct {
-	Log    LogEnvConfig
-	Server ServerEnvConfig
-	DB     DBEnvConfig
-	Login  LoginConfig
-	File   FileConfig
+	Log     LogEnvConfig
+	Server  ServerEnvConfig
+	DB      DBEnvConfig
+	Login   LoginConfig
+	File    FileConfig
+	Library []LibraryConfig
 }
 
 //LogEnvConfig ログ設定情報
@@ -24,45 +28,99 @@ type LogEnvConfig struct {
 
 //ServerEnvConfig HTTPサーバー設定情報
 type ServerEnvConfig struct {
-	PortNum  int
-	HostName string
+	PortNum        int
+	HostName       string
+	TrustedProxies []string //X-Forwarded-For・X-Real-IPヘッダを信頼するリバースプロキシのIPアドレス・CIDR
 }
 
 //DBEnvConfig DB接続設定情報
 type DBEnvConfig struct {
-	UserID     string
-	Password   string
-	HostName   string
-	PortNumber string
-	Name       string
+	Driver             string
+	FilePath           string
+	UserID             string
+	Password           string
+	HostName           string
+	PortNumber         string
+	Name               string
+	MaxOpenConns       int
+	MaxIdleConns       int
+	ConnMaxLifetimeSec int
+	ConnMaxIdleTimeSec int
 }
 
 //LoginConfig ログイン設定情報
 type LoginConfig struct {
-	PassSalt  string
-	TokenSalt string
+	PassSalt          string //旧形式（SHA-256）のパスワードハッシュの確認にだけ使用する
+	TokenSalt         string
+	PasswordAlgorithm string //パスワードハッシュの方式（argon2id, bcrypt）
+	Argon2Time        int    //argon2idの反復回数
+	Argon2MemoryKB    int    //argon2idの使用メモリ量（KB）
+	Argon2Threads     int    //argon2idの並列数
+	BcryptCost        int    //bcryptのコスト（4〜31）
+
+	AccessTokenMinutes int //アクセストークンの有効時間（分）
+	RefreshTokenDays   int //リフレッシュトークンの有効期間（日）
+
+	LoginMaxFailures      int //ユーザー名ごとの連続失敗回数の上限（達すると一時的にロックする）
+	LoginMaxFailuresPerIP int //IPアドレスごとの連続失敗回数の上限（達すると一時的にロックする）
+	LoginLockoutMinutes   int //ロックする時間（分）
+	LoginBackoffSeconds   int //失敗後に次のログインを受け付けるまでの待ち時間の初期値（秒、失敗するたびに2倍になる）
 }
 
+//パスワードハッシュの方式
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
 //FileConfig ファイル関連設定情報
 type FileConfig struct {
-	WatchDir             string
-	WatchInterval        int
-	CacheMaxCount        int
-	PreCacheImageCount   int
-	PageDirPath          string
-	PageJpegQuality      int
-	ThumbnailDirPath     string
-	ThumbnailWidth       int
-	ThumbnailJpegQuality int
+	WatchDir              string
+	WatchInterval         int
+	NotifyEnabled         bool
+	NotifyDelaySec        int
+	ScanWorkerCount       int
+	Exclude               []string //登録しないファイル・フォルダ（OSやNASが作成するものは指定しなくても登録しない）
+	DuplicateMaxDistance  int
+	MaxDeletePercent      int
+	DeleteGraceDays       int
+	CacheMaxCount         int
+	PreCacheImageCount    int
+	PreCacheMaxImageCount int
+	PreCacheLookAheadSec  int
+	PageDirPath           string
+	PageJpegQuality       int
+	ThumbnailDirPath      string
+	ThumbnailWidth        int
+	ThumbnailJpegQuality  int
 }
 
+//LibraryConfig ライブラリ設定情報
+type LibraryConfig struct {
+	Name          string //ライブラリ名（ライブラリの識別にも使用するので重複不可）
+	WatchDir      string
+	WatchInterval int
+	Direction     string   //デフォルトの読む方向（rtl=右から左、ltr=左から右）
+	Include       []string //指定がある時はいずれかに一致するファイルだけを登録する（glob形式）
+	Exclude       []string //いずれかに一致するファイル・フォルダは登録しない（glob形式、File.Excludeに追加する）
+}
+
+//デフォルトの読む方向
+const (
+	DirectionRightToLeft = "rtl"
+	DirectionLeftToRight = "ltr"
+)
+
+//デフォルトのライブラリ名（ライブラリ設定がない時に使用する）
+const defaultLibraryName = "ルートフォルダ"
+
 // 設定情報保持変数
 var envConfig = EnvConfig{
 	Log:    LogEnvConfig{Output: "stream"},
 	Server: ServerEnvConfig{PortNum: 8080, HostName: "localhost:8080"},
-	DB:     DBEnvConfig{UserID: "root", Password: "root", HostName: "127.0.0.1", PortNumber: "3306", Name: "squidgirl"},
-	Login:  LoginConfig{PassSalt: "Cp0xtdDLsHpdadfxysuemBr5a55EDgVv4hzZGyRP", TokenSalt: "Jz2tS4HdzWRNdWbD46SemE6Eh5LZUY2EVGcpkbRx"},
-	File:   FileConfig{WatchDir: "", WatchInterval: 60, CacheMaxCount: 30, PreCacheImageCount: 3, PageDirPath: "_temp/cache", PageJpegQuality: 70, ThumbnailDirPath: "_temp/thumbnail", ThumbnailWidth: 512, ThumbnailJpegQuality: 70},
+	DB:     DBEnvConfig{Driver: "mysql", FilePath: "squidgirl.db", UserID: "root", Password: "root", HostName: "127.0.0.1", PortNumber: "3306", Name: "squidgirl", MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetimeSec: 3600, ConnMaxIdleTimeSec: 600},
+	Login:  LoginConfig{PassSalt: "Cp0xtdDLsHpdadfxysuemBr5a55EDgVv4hzZGyRP", TokenSalt: "Jz2tS4HdzWRNdWbD46SemE6Eh5LZUY2EVGcpkbRx", PasswordAlgorithm: PasswordAlgorithmArgon2id, Argon2Time: 3, Argon2MemoryKB: 65536, Argon2Threads: 2, BcryptCost: 12, AccessTokenMinutes: 15, RefreshTokenDays: 30, LoginMaxFailures: 5, LoginMaxFailuresPerIP: 20, LoginLockoutMinutes: 15, LoginBackoffSeconds: 1},
+	File:   FileConfig{WatchDir: "", WatchInterval: 60, NotifyEnabled: true, NotifyDelaySec: 5, ScanWorkerCount: 0, DuplicateMaxDistance: 4, MaxDeletePercent: 50, DeleteGraceDays: 7, CacheMaxCount: 30, PreCacheImageCount: 3, PreCacheMaxImageCount: 20, PreCacheLookAheadSec: 30, PageDirPath: "_temp/cache", PageJpegQuality: 70, ThumbnailDirPath: "_temp/thumbnail", ThumbnailWidth: 512, ThumbnailJpegQuality: 70},
 }
 
 //init 初期化
@@ -74,15 +132,50 @@ func GetConfig() EnvConfig {
 	return envConfig
 }
 
+//SetConfig 設定値を差し替える（テストで設定ファイルを使用せずに設定する時に使用する）
+func SetConfig(config EnvConfig) {
+	envConfig = config
+}
+
+//GetLibraryList ライブラリ設定一覧を取得する
+//ライブラリ設定がない時はFile.WatchDirを1つのライブラリとして返す
+func GetLibraryList() []LibraryConfig {
+	if len(envConfig.Library) == 0 {
+		return []LibraryConfig{{
+			Name:          defaultLibraryName,
+			WatchDir:      envConfig.File.WatchDir,
+			WatchInterval: envConfig.File.WatchInterval,
+			Direction:     DirectionRightToLeft,
+		}}
+	}
+
+	libraryList := make([]LibraryConfig, 0, len(envConfig.Library))
+	for _, library := range envConfig.Library {
+		if library.WatchInterval == 0 {
+			library.WatchInterval = envConfig.File.WatchInterval
+		}
+		if library.Direction == "" {
+			library.Direction = DirectionRightToLeft
+		}
+		libraryList = append(libraryList, library)
+	}
+	return libraryList
+}
+
 //LoadConfig 設定ファイルから設定を読み込み（失敗時はfalseを返す）
 func LoadConfig() bool {
-	var config EnvConfig
+	config := envConfig //ファイルに記述がない項目はデフォルト値のままとする
 	if _, err := toml.DecodeFile(settingFileName, &config); err != nil {
 		fmt.Println(err)
 		return false
 	}
 
-	fmt.Printf("config=%#v\n", config)
+	//パスワード・ソルトはログに出力しない
+	logConfig := config
+	logConfig.DB.Password = secretLogValue
+	logConfig.Login.PassSalt = secretLogValue
+	logConfig.Login.TokenSalt = secretLogValue
+	fmt.Printf("config=%#v\n", logConfig)
 	envConfig = config
 	return true
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//consistencyResult は整合性確認で修復した件数を保持する
+type consistencyResult struct {
+	FolderRelinkCount  int //親フォルダを付け直したフォルダ数
+	BookRelinkCount    int //所属フォルダを付け直したアーカイブ数
+	BookRemoveCount    int //所属フォルダもファイルもなくなっていたため削除済みにしたアーカイブ数
+	HistoryRemoveCount int //アーカイブがなくなっていたため削除した履歴数
+	ThumbRemoveCount   int //アーカイブがなくなっていたため削除したサムネイル数
+}
+
+//checkConsistency は登録情報の参照先がなくなっている項目を確認して修復する
+//途中で終了した探索や旧バージョンの削除処理で残った情報を起動時に片付けるためのもので、探索開始前に呼び出すこと
+func checkConsistency(ctx context.Context) (consistencyResult, error) {
+	var result consistencyResult
+	folderList, err := db.SelectFolderAll(ctx)
+	if err != nil {
+		return result, err
+	}
+	folderHashMap := make(map[string]bool)
+	for _, folder := range folderList {
+		folderHashMap[folder.Hash] = true
+	}
+	rootPathMap := make(map[string]bool)
+	for _, library := range config.GetLibraryList() {
+		rootPathMap[filepath.Clean(library.WatchDir)] = true
+	}
+
+	//フォルダのハッシュはパスから決まるため、親フォルダのハッシュはパスから付け直す
+	//親フォルダが未登録の時も次回の探索で同じハッシュで登録される
+	for _, folder := range folderList {
+		parentHash := ""
+		if !rootPathMap[filepath.Clean(folder.FilePath)] {
+			parentHash = db.CreateFolderHash(filepath.Dir(folder.FilePath))
+		}
+		if folder.ParentHash == parentHash || (folder.ParentHash != "" && folderHashMap[folder.ParentHash]) {
+			continue
+		}
+		if err := db.UpdateFolderParent(ctx, folder.Hash, parentHash); err != nil {
+			return result, err
+		}
+		result.FolderRelinkCount++
+	}
+
+	//所属フォルダがないアーカイブは、ファイルと同じ場所のフォルダに付け直すかファイルがなければ削除済みにする
+	//ライブラリのルートフォルダが読み込めない時（ディスクが未接続など）はそのライブラリのアーカイブは削除済みにしない
+	bookList, err := db.SelectBookAll(ctx)
+	if err != nil {
+		return result, err
+	}
+	isAvailable := newLibraryAvailableChecker()
+	activeCount := 0
+	relinkList := make([]db.BookTable, 0)
+	removeIDList := make([]int64, 0)
+	for _, book := range bookList {
+		if book.Deleted {
+			continue //削除済みのアーカイブは所属フォルダがなくても猶予期間中は残す
+		}
+		activeCount++
+		if folderHashMap[book.FolderHash] {
+			continue
+		}
+		folderHash := db.CreateFolderHash(filepath.Dir(book.FilePath))
+		if folderHashMap[folderHash] {
+			book.FolderHash = folderHash
+			relinkList = append(relinkList, book)
+		} else if !isAvailable(book.FilePath) {
+			continue
+		} else if _, err := os.Stat(book.FilePath); os.IsNotExist(err) {
+			removeIDList = append(removeIDList, book.ID)
+		} else {
+			//フォルダごと未登録の時は次回の探索でフォルダを登録した時に引き継ぐ
+			fmt.Printf("checkConsistency 所属フォルダ未登録 path=%s\n", book.FilePath)
+		}
+	}
+	if err := db.UpdateBookList(ctx, relinkList); err != nil {
+		return result, err
+	}
+	result.BookRelinkCount = len(relinkList)
+
+	//履歴・サムネイルは猶予期間が過ぎてPurgeFileで削除するまで残す
+	maxPercent := config.GetConfig().File.MaxDeletePercent
+	if maxPercent > 0 && len(removeIDList) > minDeleteLimitCount && len(removeIDList)*100 > activeCount*maxPercent {
+		fmt.Printf("checkConsistency 見つからなくなったアーカイブが多すぎるため削除しない count=%d/%d\n", len(removeIDList), activeCount)
+	} else {
+		if err := db.SoftDeleteBookList(ctx, removeIDList, time.Now()); err != nil {
+			return result, err
+		}
+		result.BookRemoveCount = len(removeIDList)
+	}
+
+	//アーカイブ情報がなくなった履歴とサムネイルを削除する
+	bookHashMap := make(map[string]bool)
+	for _, book := range bookList {
+		bookHashMap[book.Hash] = true
+	}
+	historyList, err := db.SelectHistoryAll(ctx)
+	if err != nil {
+		return result, err
+	}
+	historyIDList := make([]int64, 0)
+	for _, history := range historyList {
+		if !bookHashMap[history.BookHash] {
+			historyIDList = append(historyIDList, history.ID)
+		}
+	}
+	if err := db.DeleteHistoryList(ctx, historyIDList); err != nil {
+		return result, err
+	}
+	result.HistoryRemoveCount = len(historyIDList)
+	result.ThumbRemoveCount = removeOrphanThumbnail(bookHashMap)
+	return result, nil
+}
+
+//removeOrphanThumbnail はアーカイブ情報がないサムネイルファイルを削除し、削除した件数を返す
+func removeOrphanThumbnail(bookHashMap map[string]bool) int {
+	thum := NewThumbnail()
+	fileInfoList, err := ioutil.ReadDir(thum.dirPath)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, fileInfo := range fileInfoList {
+		name := fileInfo.Name()
+		if fileInfo.IsDir() || filepath.Ext(name) != ".jpg" {
+			continue
+		}
+		hash := strings.TrimSuffix(name, ".jpg")
+		if bookHashMap[hash] {
+			continue
+		}
+		if thum.RemoveFile(hash) == nil {
+			count++
+		}
+	}
+	return count
+}
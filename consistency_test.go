@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	ctx := context.Background()
+	offlineDir := filepath.Join(filepath.Dir(testLibraryDir), "offline")
+	baseConfig := config.GetConfig()
+	testConfig := baseConfig
+	testConfig.Library = append([]config.LibraryConfig{{Name: "offline", WatchDir: offlineDir}}, baseConfig.Library...)
+	config.SetConfig(testConfig)
+	defer config.SetConfig(baseConfig)
+
+	//所属フォルダもファイルもないアーカイブ（読み込めるライブラリと読み込めないライブラリ）
+	missingBook, err := db.InsertBook(ctx, "missingfolder", filepath.Join(testLibraryDir, "missing", "missing.zip"), "missing", 100, 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	offlineBook, err := db.InsertBook(ctx, "offlinefolder", filepath.Join(offlineDir, "offline.zip"), "offline", 100, 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertHistory(ctx, testUser, missingBook.Hash, 0, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := checkConsistency(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BookRemoveCount != 1 || result.HistoryRemoveCount != 0 {
+		t.Errorf("result=%v", result)
+	}
+
+	//ファイルがなくなったアーカイブは削除済みにして履歴は残す
+	book, err := db.SelectBookFromHash(ctx, missingBook.Hash)
+	if err != nil || book.Hash == "" || !book.Deleted {
+		t.Errorf("missing book=%v err=%v", book, err)
+	}
+	history, err := db.SelectHistory(ctx, testUser, missingBook.Hash)
+	if err != nil || history.ID == 0 {
+		t.Errorf("history=%v err=%v", history, err)
+	}
+
+	//読み込めないライブラリのアーカイブは削除済みにしない
+	book, err = db.SelectBookFromHash(ctx, offlineBook.Hash)
+	if err != nil || book.Hash == "" || book.Deleted {
+		t.Errorf("offline book=%v err=%v", book, err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+
+	imageresize "github.com/nfnt/resize"
+)
+
+const (
+	coverHashWidth  = 9 //横に隣接する画素の明るさを比較するため1画素多くする
+	coverHashHeight = 8
+)
+
+//createCoverHash は表紙画像の知覚ハッシュ（dHash）を16進数の文字列で返す
+//縮小・再圧縮された画像でも近い値になるため、ハミング距離で似た画像かどうかを判定できる
+func createCoverHash(img image.Image) string {
+	small := imageresize.Resize(coverHashWidth, coverHashHeight, img, imageresize.Bilinear)
+
+	var hash uint64
+	for y := 0; y < coverHashHeight; y++ {
+		for x := 0; x < coverHashWidth-1; x++ {
+			left := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+//coverHashDistance は2つの知覚ハッシュのハミング距離を返す（変換できない時は-1を返す）
+func coverHashDistance(hash1 string, hash2 string) int {
+	value1, err := strconv.ParseUint(hash1, 16, 64)
+	if err != nil {
+		return -1
+	}
+	value2, err := strconv.ParseUint(hash2, 16, 64)
+	if err != nil {
+		return -1
+	}
+	return bits.OnesCount64(value1 ^ value2)
+}
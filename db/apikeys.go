@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+//テーブル名
+const apiKeyTableName = "api_keys"
+
+//apiKeyLength はAPIキーのランダム部分の長さ（バイト数）
+const apiKeyLength = 32
+
+//apiKeyPrefixLength は一覧で表示するためにAPIキーの先頭を保存する文字数
+const apiKeyPrefixLength = 12
+
+//APIキーの先頭に付ける文字列（キーの種類を判別しやすくする）
+const apiKeyHeader = "sgk_"
+
+//APIキーの権限範囲
+const (
+	APIKeyScopeRead  = "read"  //読み取り専用
+	APIKeyScopeWrite = "write" //読み書き
+)
+
+//APIKeyNoExpire は有効期限なしのAPIキーの有効期限
+var APIKeyNoExpire = time.Unix(0, 0).UTC()
+
+//APIKeyTable APIキー情報テーブル
+type APIKeyTable struct {
+	ID        int64     `db:"id"`
+	UserName  string    `db:"user_name"`
+	Name      string    `db:"name"`
+	KeyHash   string    `db:"key_hash"`   //キーのSHA-256（キーそのものは保存しない）
+	KeyPrefix string    `db:"key_prefix"` //一覧表示用のキーの先頭部分
+	Scope     string    `db:"scope"`
+	ExpiresAt time.Time `db:"expires_at"` //APIKeyNoExpireの時は有効期限なし
+	CreatedAt time.Time `db:"created_at"`
+}
+
+//IsExpired は指定した時刻にAPIキーの有効期限が切れているかどうかを返す
+func (record APIKeyTable) IsExpired(now time.Time) bool {
+	return !record.ExpiresAt.Equal(APIKeyNoExpire) && record.ExpiresAt.Before(now)
+}
+
+//IsValidAPIKeyScope はAPIキーの権限範囲として正しい値かどうかを返す
+func IsValidAPIKeyScope(scope string) bool {
+	return scope == APIKeyScopeRead || scope == APIKeyScopeWrite
+}
+
+//CreateAPIKey は新しいAPIキーを生成して登録し、キーを返す（キーはこの時だけ取得できる）
+func CreateAPIKey(ctx context.Context, userName string, name string, scope string, expiresAt time.Time) (string, error) {
+	if userName == "" || name == "" || !IsValidAPIKeyScope(scope) {
+		return "", fmt.Errorf("パラメーターエラー")
+	}
+
+	keyBytes := make([]byte, apiKeyLength)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", err
+	}
+	key := apiKeyHeader + hex.EncodeToString(keyBytes)
+	record := APIKeyTable{
+		UserName:  userName,
+		Name:      name,
+		KeyHash:   createRefreshTokenHash(key),
+		KeyPrefix: key[:apiKeyPrefixLength],
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	err := dbStore.InsertAPIKey(ctx, record)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+//SelectAPIKey はAPIキーの登録情報を返す（見つからない時はIDが0）
+func SelectAPIKey(ctx context.Context, key string) (APIKeyTable, error) {
+	var result APIKeyTable
+	recordList, err := dbStore.SelectAPIKeyList(ctx, createRefreshTokenHash(key))
+	if err != nil {
+		return result, err
+	}
+
+	if len(recordList) == 0 {
+		return result, nil
+	}
+	return recordList[0], nil
+}
+
+//SelectAPIKeyFromID は指定したIDのAPIキーの登録情報を返す（見つからない時はIDが0）
+func SelectAPIKeyFromID(ctx context.Context, id int64) (APIKeyTable, error) {
+	var result APIKeyTable
+	recordList, err := dbStore.SelectAPIKeyListFromID(ctx, id)
+	if err != nil {
+		return result, err
+	}
+
+	if len(recordList) == 0 {
+		return result, nil
+	}
+	return recordList[0], nil
+}
+
+//SelectAPIKeyListFromUser はユーザーのAPIキーの一覧を返す
+func SelectAPIKeyListFromUser(ctx context.Context, userName string) ([]APIKeyTable, error) {
+	recordList, err := dbStore.SelectAPIKeyListFromUser(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	return recordList, nil
+}
+
+//SelectAPIKeyAll はすべてのユーザーのAPIキーの一覧を返す
+func SelectAPIKeyAll(ctx context.Context) ([]APIKeyTable, error) {
+	recordList, err := dbStore.SelectAPIKeyListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return recordList, nil
+}
+
+//DeleteAPIKey はAPIキーを削除する
+func DeleteAPIKey(ctx context.Context, id int64) error {
+	if id == 0 {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.DeleteAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//DeleteAPIKeyOfUser はユーザーのAPIキーをすべて削除する
+func DeleteAPIKeyOfUser(ctx context.Context, userName string) error {
+	if userName == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.DeleteAPIKeyOfUser(ctx, userName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStore) InsertAPIKey(ctx context.Context, record APIKeyTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(apiKeyTableName).
+		Columns("user_name", "name", "key_hash", "key_prefix", "scope", "expires_at", "created_at").
+		Record(record).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqlStore) SelectAPIKeyList(ctx context.Context, keyHash string) ([]APIKeyTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []APIKeyTable
+	_, err := session.Select("*").From(apiKeyTableName).Where("key_hash = ?", keyHash).LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectAPIKeyListFromID(ctx context.Context, id int64) ([]APIKeyTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []APIKeyTable
+	_, err := session.Select("*").From(apiKeyTableName).Where("id = ?", id).LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectAPIKeyListFromUser(ctx context.Context, userName string) ([]APIKeyTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []APIKeyTable
+	_, err := session.Select("*").From(apiKeyTableName).Where("user_name = ?", userName).OrderBy("id").LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectAPIKeyListAll(ctx context.Context) ([]APIKeyTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []APIKeyTable
+	_, err := session.Select("*").From(apiKeyTableName).OrderBy("id").LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) DeleteAPIKey(ctx context.Context, id int64) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(apiKeyTableName).
+		Where("id = ?", id).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStore) DeleteAPIKeyOfUser(ctx context.Context, userName string) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(apiKeyTableName).
+		Where("user_name = ?", userName).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package db
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/gocraft/dbr"
@@ -12,52 +14,125 @@ import (
 //テーブル名
 const bookTableName = "books"
 
+//アーカイブの確認結果
+const (
+	BookStatusUnchecked = 0 //未確認（確認処理追加前に登録されたもの）
+	BookStatusOK        = 1
+	BookStatusBroken    = 2 //開けない・ページ画像を読み込めない
+)
+
+//AgeRatingUnread はアーカイブの対象年齢をまだ読み込んでいないことを表す（制限なしとして扱う）
+const AgeRatingUnread = -1
+
 //BookTable アーカイブ情報テーブル
 type BookTable struct {
-	ID         int64     `db:"id"`
-	Hash       string    `db:"hash"`
-	FolderHash string    `db:"folder_hash"`
-	FilePath   string    `db:"file_path"`
-	FileSize   int       `db:"file_size"`
-	Page       int       `db:"page"`
-	ModTime    time.Time `db:"mod_time"`
+	ID           int64     `db:"id"`
+	Hash         string    `db:"hash"`
+	FolderHash   string    `db:"folder_hash"`
+	FilePath     string    `db:"file_path"`
+	FileSize     int       `db:"file_size"`
+	Page         int       `db:"page"`
+	ModTime      time.Time `db:"mod_time"`
+	Fingerprint  string    `db:"fingerprint"`
+	Status       int       `db:"status"`
+	ErrorMessage string    `db:"error_message"`
+	CoverHash    string    `db:"cover_hash"`
+	Deleted      bool      `db:"deleted"`    //ファイルが見つからなくなった（猶予期間後に完全に削除する）
+	DeletedAt    time.Time `db:"deleted_at"` //削除済みでない時はnotDeletedTime
+	AgeRating    int       `db:"age_rating"` //対象年齢（0は制限なし、AgeRatingUnreadは未取得）
 }
 
-func InsertBook(folderHash string, filePath string, fileSize int, page int, modTime time.Time) error {
-	fmt.Printf("InsertBook folderHash=%s, filePath=%s, fileSize=%d, page=%d, modTime=%s\n", folderHash, filePath, fileSize, page, modTime)
+var (
+	notDeletedTime = time.Unix(0, 0).UTC() //削除済みでないアーカイブの削除日時
+)
+
+//InsertBook はアーカイブ情報を登録する（ハッシュは登録時に採番し以降は変更しない）
+func InsertBook(ctx context.Context, folderHash string, filePath string, fingerprint string, fileSize int, page int, modTime time.Time) (BookTable, error) {
+	fmt.Printf("InsertBook folderHash=%s, filePath=%s, fingerprint=%s, fileSize=%d, page=%d, modTime=%s\n", folderHash, filePath, fingerprint, fileSize, page, modTime)
 	if filePath == "" {
-		return fmt.Errorf("パラメーターエラー")
+		return BookTable{}, fmt.Errorf("パラメーターエラー")
 	}
 
-	hash := CreateBookHash(filePath)
-	record := BookTable{FolderHash: folderHash, Hash: hash, FilePath: filePath, FileSize: fileSize, Page: page, ModTime: modTime}
-	err := insertBook(nil, record)
+	record := NewBookRecord(folderHash, filePath, fingerprint, fileSize, page, modTime)
+	err := dbStore.InsertBook(ctx, record)
 	if err != nil {
 		fmt.Printf("InsertBook err=%s\n", err)
+		return BookTable{}, err
+	}
+	return record, nil
+}
+
+//InsertBookList は複数のアーカイブ情報をまとめて登録する（NewBookRecordで生成した情報を渡すこと）
+func InsertBookList(ctx context.Context, recordList []BookTable) error {
+	fmt.Printf("InsertBookList len=%d\n", len(recordList))
+	if len(recordList) == 0 {
+		return nil
+	}
+
+	err := execBatch(len(recordList), func(start int, end int) error {
+		return dbStore.InsertBookList(ctx, recordList[start:end])
+	})
+	if err != nil {
+		fmt.Printf("InsertBookList err=%s\n", err)
 		return err
 	}
 	return nil
 }
 
-func UpdateBook(folderHash string, filePath string, fileSize int, page int, modTime time.Time) error {
-	fmt.Printf("UpdateBook folderHash=%s, filePath=%s, fileSize=%d, page=%d, modTime=%s\n", folderHash, filePath, fileSize, page, modTime)
-	if filePath == "" {
-		return fmt.Errorf("パラメーターエラー")
+//NewBookRecord は新しく登録するアーカイブ情報を生成する
+func NewBookRecord(folderHash string, filePath string, fingerprint string, fileSize int, page int, modTime time.Time) BookTable {
+	hash := CreateBookHash(filePath, fingerprint)
+	return BookTable{FolderHash: folderHash, Hash: hash, FilePath: filePath, FileSize: fileSize, Page: page, ModTime: modTime, Fingerprint: fingerprint, DeletedAt: notDeletedTime, AgeRating: AgeRatingUnread}
+}
+
+//UpdateBookList は複数のアーカイブ情報（すべての項目）を1つのトランザクションでまとめて更新する
+func UpdateBookList(ctx context.Context, recordList []BookTable) error {
+	fmt.Printf("UpdateBookList len=%d\n", len(recordList))
+	if len(recordList) == 0 {
+		return nil
+	}
+	for _, record := range recordList {
+		if record.Hash == "" || record.FilePath == "" {
+			return fmt.Errorf("パラメーターエラー")
+		}
+	}
+
+	err := dbStore.UpdateBookList(ctx, recordList)
+	if err != nil {
+		fmt.Printf("UpdateBookList err=%s\n", err)
+		return err
+	}
+	return nil
+}
+
+//RestoreBook はアーカイブ情報を削除済みでない状態に戻す（更新はUpdateBookListで行う）
+func RestoreBook(record BookTable) BookTable {
+	record.Deleted = false
+	record.DeletedAt = notDeletedTime
+	return record
+}
+
+//SoftDeleteBookList は複数のアーカイブ情報をまとめて削除済みにする（履歴は完全に削除するまで残す）
+func SoftDeleteBookList(ctx context.Context, idList []int64, deletedAt time.Time) error {
+	fmt.Printf("SoftDeleteBookList len=%d, deletedAt=%s\n", len(idList), deletedAt)
+	if len(idList) == 0 {
+		return nil
 	}
 
-	hash := CreateBookHash(filePath)
-	record := BookTable{FolderHash: folderHash, Hash: hash, FilePath: filePath, FileSize: fileSize, Page: page, ModTime: modTime}
-	err := updateBook(nil, record)
+	err := execBatch(len(idList), func(start int, end int) error {
+		return dbStore.SoftDeleteBookList(ctx, idList[start:end], deletedAt)
+	})
 	if err != nil {
-		fmt.Printf("UpdateBook err=%s\n", err)
+		fmt.Printf("SoftDeleteBookList err=%s\n", err)
 		return err
 	}
 	return nil
 }
 
-func DeleteBook(id int64) error {
+//DeleteBook はアーカイブ情報と、そのアーカイブの履歴を削除する
+func DeleteBook(ctx context.Context, id int64) error {
 	fmt.Printf("DeleteBook id=%d\n", id)
-	err := deleteBook(nil, id)
+	err := dbStore.DeleteBookList(ctx, []int64{id})
 	if err != nil {
 		fmt.Printf("DeleteBook err=%s\n", err)
 		return err
@@ -65,10 +140,27 @@ func DeleteBook(id int64) error {
 	return nil
 }
 
-func SelectBookFromHash(hash string) (BookTable, error) {
+//DeleteBookList は複数のアーカイブ情報と、それらのアーカイブの履歴をまとめて削除する
+func DeleteBookList(ctx context.Context, idList []int64) error {
+	fmt.Printf("DeleteBookList len=%d\n", len(idList))
+	if len(idList) == 0 {
+		return nil
+	}
+
+	err := execBatch(len(idList), func(start int, end int) error {
+		return dbStore.DeleteBookList(ctx, idList[start:end])
+	})
+	if err != nil {
+		fmt.Printf("DeleteBookList err=%s\n", err)
+		return err
+	}
+	return nil
+}
+
+func SelectBookFromHash(ctx context.Context, hash string) (BookTable, error) {
 	fmt.Printf("SelectBook hash=%s\n", hash)
 	var result BookTable
-	recordList, err := selectBookList(nil, hash)
+	recordList, err := dbStore.SelectBookList(ctx, hash)
 	if err != nil {
 		fmt.Printf("SelectBook err=%s\n", err)
 		return result, err
@@ -81,15 +173,40 @@ func SelectBookFromHash(hash string) (BookTable, error) {
 	return recordList[0], nil
 }
 
-func SelectBook(filePath string) (BookTable, error) {
+func SelectBook(ctx context.Context, filePath string) (BookTable, error) {
 	fmt.Printf("SelectBook filePath=%s\n", filePath)
-	hash := CreateBookHash(filePath)
-	return SelectBookFromHash(hash)
+	var result BookTable
+	recordList, err := dbStore.SelectBookListFromPath(ctx, filePath)
+	if err != nil {
+		fmt.Printf("SelectBook err=%s\n", err)
+		return result, err
+	}
+
+	if len(recordList) == 0 {
+		fmt.Printf("SelectBook len==0\n")
+		return result, nil
+	}
+	return recordList[0], nil
+}
+
+//SelectBookListFromFingerprint は同じファイル内容を持つアーカイブ情報を取得する
+func SelectBookListFromFingerprint(ctx context.Context, fingerprint string) ([]BookTable, error) {
+	fmt.Printf("SelectBookListFromFingerprint fingerprint=%s\n", fingerprint)
+	if fingerprint == "" {
+		return []BookTable{}, nil
+	}
+	recordList, err := dbStore.SelectBookListFromFingerprint(ctx, fingerprint)
+	if err != nil {
+		fmt.Printf("SelectBookListFromFingerprint err=%s\n", err)
+		return nil, err
+	}
+
+	return recordList, nil
 }
 
-func SelectBookListFromFolder(folderHash string) ([]BookTable, error) {
+func SelectBookListFromFolder(ctx context.Context, folderHash string) ([]BookTable, error) {
 	fmt.Printf("SelectBookListFromFolder folderHash=%s\n", folderHash)
-	recordList, err := selectBookListFromFolder(nil, folderHash)
+	recordList, err := dbStore.SelectBookListFromFolder(ctx, folderHash)
 	if err != nil {
 		fmt.Printf("SelectBookListFromFolder err=%s\n", err)
 		return nil, err
@@ -98,9 +215,45 @@ func SelectBookListFromFolder(folderHash string) ([]BookTable, error) {
 	return recordList, nil
 }
 
-func SelectBookAll() ([]BookTable, error) {
+//SelectBookListFromStatus は指定した確認結果のアーカイブ情報を取得する
+func SelectBookListFromStatus(ctx context.Context, status int) ([]BookTable, error) {
+	fmt.Printf("SelectBookListFromStatus status=%d\n", status)
+	recordList, err := dbStore.SelectBookListFromStatus(ctx, status)
+	if err != nil {
+		fmt.Printf("SelectBookListFromStatus err=%s\n", err)
+		return nil, err
+	}
+
+	return recordList, nil
+}
+
+//SelectBookListFromDeleted は指定した日時より前に削除済みになったアーカイブ情報を取得する
+func SelectBookListFromDeleted(ctx context.Context, before time.Time) ([]BookTable, error) {
+	fmt.Printf("SelectBookListFromDeleted before=%s\n", before)
+	recordList, err := dbStore.SelectBookListFromDeleted(ctx, before)
+	if err != nil {
+		fmt.Printf("SelectBookListFromDeleted err=%s\n", err)
+		return nil, err
+	}
+
+	return recordList, nil
+}
+
+//ExcludeDeletedBook は削除済みのアーカイブ情報を除いたリストを返す
+//（アーカイブ情報の取得は削除済みのものも含むため、表示・確認に使用する時は除くこと）
+func ExcludeDeletedBook(recordList []BookTable) []BookTable {
+	resultList := make([]BookTable, 0, len(recordList))
+	for _, record := range recordList {
+		if !record.Deleted {
+			resultList = append(resultList, record)
+		}
+	}
+	return resultList
+}
+
+func SelectBookAll(ctx context.Context) ([]BookTable, error) {
 	fmt.Printf("SelectBookAll\n")
-	recordList, err := selectBookListAll(nil)
+	recordList, err := dbStore.SelectBookListAll(ctx)
 	if err != nil {
 		fmt.Printf("SelectBookAll err=%s\n", err)
 		return nil, err
@@ -113,17 +266,32 @@ func SelectBookAll() ([]BookTable, error) {
 	return recordList, nil
 }
 
-func insertBook(session *dbr.Session, record BookTable) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) InsertBook(ctx context.Context, record BookTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(bookTableName).
+		Columns("hash", "folder_hash", "file_path", "file_size", "page", "mod_time", "fingerprint", "status", "error_message", "cover_hash", "deleted", "deleted_at", "age_rating").
+		Record(record).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
 
-	_, err = session.InsertInto(bookTableName).
-		Columns("hash", "folder_hash", "file_path", "file_size", "page", "mod_time").
-		Record(record).
-		Exec()
+	return nil
+}
+
+func (store *sqlStore) InsertBookList(ctx context.Context, recordList []BookTable) error {
+	session := store.conn.NewSession(nil)
+	return insertBookList(ctx, session, recordList)
+}
+
+//insertBookList は指定したセッション・トランザクションで複数のアーカイブ情報を登録する
+func insertBookList(ctx context.Context, runner dbr.SessionRunner, recordList []BookTable) error {
+	stmt := runner.InsertInto(bookTableName).
+		Columns("hash", "folder_hash", "file_path", "file_size", "page", "mod_time", "fingerprint", "status", "error_message", "cover_hash", "deleted", "deleted_at", "age_rating")
+	for i := range recordList {
+		stmt = stmt.Record(&recordList[i])
+	}
+	_, err := stmt.ExecContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -131,49 +299,97 @@ func insertBook(session *dbr.Session, record BookTable) error {
 	return nil
 }
 
-func updateBook(session *dbr.Session, record BookTable) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) UpdateBookList(ctx context.Context, recordList []BookTable) error {
+	session := store.conn.NewSession(nil)
+	tx, err := session.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	defer tx.RollbackUnlessCommitted()
 
-	_, err = session.Update(bookTableName).
-		Set("file_size", record.FileSize).
-		Set("page", record.Page).
-		Set("mod_time", record.ModTime).
-		Where("hash = ?", record.Hash).
-		Exec()
+	if err := updateBookList(ctx, tx, recordList); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//updateBookList は指定したセッション・トランザクションで複数のアーカイブ情報（すべての項目）を更新する
+func updateBookList(ctx context.Context, runner dbr.SessionRunner, recordList []BookTable) error {
+	for _, record := range recordList {
+		_, err := runner.Update(bookTableName).
+			Set("folder_hash", record.FolderHash).
+			Set("file_path", record.FilePath).
+			Set("file_size", record.FileSize).
+			Set("page", record.Page).
+			Set("mod_time", record.ModTime).
+			Set("fingerprint", record.Fingerprint).
+			Set("status", record.Status).
+			Set("error_message", record.ErrorMessage).
+			Set("cover_hash", record.CoverHash).
+			Set("deleted", record.Deleted).
+			Set("deleted_at", record.DeletedAt).
+			Set("age_rating", record.AgeRating).
+			Where("hash = ?", record.Hash).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *sqlStore) SoftDeleteBookList(ctx context.Context, idList []int64, deletedAt time.Time) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(bookTableName).
+		Set("deleted", true).
+		Set("deleted_at", deletedAt).
+		Where("id IN ? AND deleted = ?", idList, false).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func deleteBook(session *dbr.Session, id int64) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) DeleteBookList(ctx context.Context, idList []int64) error {
+	session := store.conn.NewSession(nil)
+	tx, err := session.BeginTx(ctx, nil)
 	if err != nil {
-		return nil
+		return err
 	}
-	defer session.Close()
-	_, err = session.DeleteFrom(bookTableName).
-		Where("id = ?", id).
-		Exec()
+	defer tx.RollbackUnlessCommitted()
+
+	//履歴を先に削除する
+	_, err = tx.DeleteFrom(historyTableName).
+		Where("book_hash IN (SELECT hash FROM "+bookTableName+" WHERE id IN ?)", idList).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = tx.DeleteFrom(bookTableName).
+		Where("id IN ?", idList).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func selectBookList(session *dbr.Session, hash string) ([]BookTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) SelectBookList(ctx context.Context, hash string) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []BookTable
+	_, err := session.Select("*").From(bookTableName).Where("hash = ?", hash).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
 
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectBookListFromPath(ctx context.Context, filePath string) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []BookTable
-	_, err = session.Select("*").From(bookTableName).Where("hash = ?", hash).Load(&resultList)
+	_, err := session.Select("*").From(bookTableName).Where("file_path = ?", filePath).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -181,15 +397,32 @@ func selectBookList(session *dbr.Session, hash string) ([]BookTable, error) {
 	return resultList, nil
 }
 
-func selectBookListFromFolder(session *dbr.Session, folderHash string) ([]BookTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) SelectBookListFromFingerprint(ctx context.Context, fingerprint string) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []BookTable
+	_, err := session.Select("*").From(bookTableName).Where("fingerprint = ?", fingerprint).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
 
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectBookListFromFolder(ctx context.Context, folderHash string) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []BookTable
+	_, err := session.Select("*").From(bookTableName).Where("folder_hash = ?", folderHash).LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectBookListFromStatus(ctx context.Context, status int) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []BookTable
-	_, err = session.Select("*").From(bookTableName).Where("folder_hash = ?", folderHash).Load(&resultList)
+	_, err := session.Select("*").From(bookTableName).Where("status = ?", status).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -197,15 +430,21 @@ func selectBookListFromFolder(session *dbr.Session, folderHash string) ([]BookTa
 	return resultList, nil
 }
 
-func selectBookListAll(session *dbr.Session) ([]BookTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) SelectBookListFromDeleted(ctx context.Context, before time.Time) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []BookTable
+	_, err := session.Select("*").From(bookTableName).Where("deleted = ? AND deleted_at < ?", true, before).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
 
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectBookListAll(ctx context.Context) ([]BookTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []BookTable
-	_, err = session.Select("*").From(bookTableName).Load(&resultList)
+	_, err := session.Select("*").From(bookTableName).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -213,8 +452,10 @@ func selectBookListAll(session *dbr.Session) ([]BookTable, error) {
 	return resultList, nil
 }
 
-func CreateBookHash(filePath string) string {
-	hashBytes := sha256.Sum256([]byte(filePath))
+//CreateBookHash は新しく登録するアーカイブのハッシュを生成する
+//ハッシュはアーカイブを識別するためのもので、移動・名前変更されても変更しない
+func CreateBookHash(filePath string, fingerprint string) string {
+	hashBytes := sha256.Sum256([]byte(filePath + "\n" + fingerprint + "\n" + strconv.FormatInt(time.Now().UnixNano(), 10)))
 	hash := hex.EncodeToString(hashBytes[:])
 	return hash
 }
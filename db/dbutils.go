@@ -1,35 +1,108 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql" //dbrで使用する
 	"github.com/gocraft/dbr"
+	"github.com/gocraft/dbr/dialect"
+	_ "modernc.org/sqlite" //SQLite（CGO不要）で使用する
 
 	"github.com/mryp/squidgirl-go/config"
 )
 
-//ConnectDB DB接続
-func ConnectDB() (*dbr.Session, error) {
-	dbConfig := config.GetConfig().DB
-	db, err := dbr.Open("mysql", dbConfig.UserID+":"+dbConfig.Password+"@tcp("+dbConfig.HostName+":"+dbConfig.PortNumber+")/"+dbConfig.Name+"?parseTime=true", nil)
+//DB種別
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
+//sqlStore はSQLデータベースをデータ保存先とする
+type sqlStore struct {
+	conn   *dbr.Connection
+	driver string
+}
+
+//OpenStore は設定情報のDB種別に合わせてデータ保存先を生成して返す
+func OpenStore(dbConfig config.DBEnvConfig) (Store, error) {
+	driver := dbConfig.Driver
+	if driver == "" {
+		driver = DriverMySQL
+	}
+
+	var conn *dbr.Connection
+	var err error
+	switch driver {
+	case DriverMySQL:
+		conn, err = ConnectDB(dbConfig)
+	case DriverSQLite:
+		conn, err = ConnectSQLite(dbConfig)
+	default:
+		err = fmt.Errorf("未対応のDB種別 driver=%s", driver)
+	}
 	if err != nil {
-		fmt.Printf("connectDB err=%v\n", err)
 		return nil, err
 	}
 
-	dbsession := db.NewSession(nil)
-	return dbsession, nil
+	return &sqlStore{conn: conn, driver: driver}, nil
 }
 
-func ConnectDBRecheck(session *dbr.Session) (*dbr.Session, error) {
-	if session == nil {
-		newSession, err := ConnectDB()
-		if err != nil {
-			return nil, err
-		}
-		session = newSession
+//ConnectDB は設定情報からMySQLの接続プールを生成して返す
+func ConnectDB(dbConfig config.DBEnvConfig) (*dbr.Connection, error) {
+	conn, err := dbr.Open("mysql", dbConfig.UserID+":"+dbConfig.Password+"@tcp("+dbConfig.HostName+":"+dbConfig.PortNumber+")/"+dbConfig.Name+"?parseTime=true", nil)
+	if err != nil {
+		fmt.Printf("ConnectDB err=%v\n", err)
+		return nil, err
+	}
+
+	setConnectionPool(conn, dbConfig)
+	if err := conn.Ping(); err != nil {
+		fmt.Printf("ConnectDB ping err=%v\n", err)
+		conn.Close()
+		return nil, err
 	}
+	return conn, nil
+}
+
+//ConnectSQLite は設定情報からSQLiteの接続プールを生成して返す
+func ConnectSQLite(dbConfig config.DBEnvConfig) (*dbr.Connection, error) {
+	dirPath := filepath.Dir(dbConfig.FilePath)
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		os.MkdirAll(dirPath, 0777)
+	}
+
+	dsn := "file:" + dbConfig.FilePath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		fmt.Printf("ConnectSQLite err=%v\n", err)
+		return nil, err
+	}
+
+	//dbr.Openはmattn/go-sqlite3のドライバ名を前提としているため直接生成する
+	conn := &dbr.Connection{DB: sqlDB, Dialect: dialect.SQLite3, EventReceiver: &dbr.NullEventReceiver{}}
+	setConnectionPool(conn, dbConfig)
+	conn.SetMaxOpenConns(1) //SQLiteは書き込みが1接続ずつなのでロック待ちを避ける
+	if err := conn.Ping(); err != nil {
+		fmt.Printf("ConnectSQLite ping err=%v\n", err)
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+//setConnectionPool は設定情報から接続プールの上限値を設定する
+func setConnectionPool(conn *dbr.Connection, dbConfig config.DBEnvConfig) {
+	conn.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	conn.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	conn.SetConnMaxLifetime(time.Duration(dbConfig.ConnMaxLifetimeSec) * time.Second)
+	conn.SetConnMaxIdleTime(time.Duration(dbConfig.ConnMaxIdleTimeSec) * time.Second)
+}
 
-	return session, nil
+//Close は接続プールを閉じる
+func (store *sqlStore) Close() error {
+	return store.conn.Close()
 }
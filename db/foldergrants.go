@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//テーブル名
+const folderGrantTableName = "folder_grants"
+
+//FolderGrantTable フォルダ閲覧許可情報テーブル
+//ユーザー名を指定した時はそのユーザー、権限レベルを指定した時はその権限レベルのユーザー全員に許可する
+type FolderGrantTable struct {
+	ID         int64     `db:"id"`
+	FolderHash string    `db:"folder_hash"`
+	UserName   string    `db:"user_name"`  //ユーザーに許可しない時は空
+	Permission int       `db:"permission"` //権限レベルに許可しない時は0
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+//InsertFolderGrant はフォルダの閲覧許可を登録する（ユーザー名・権限レベルのどちらか一方を指定する）
+func InsertFolderGrant(ctx context.Context, folderHash string, userName string, permission int) error {
+	if folderHash == "" || (userName == "") == (permission == 0) {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	record := FolderGrantTable{FolderHash: folderHash, UserName: userName, Permission: permission, CreatedAt: time.Now()}
+	err := dbStore.InsertFolderGrant(ctx, record)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//SelectFolderGrantListFromUser は指定したユーザー名または権限レベルに対する閲覧許可を返す
+func SelectFolderGrantListFromUser(ctx context.Context, userName string, permission int) ([]FolderGrantTable, error) {
+	recordList, err := dbStore.SelectFolderGrantListFromUser(ctx, userName, permission)
+	if err != nil {
+		return nil, err
+	}
+	return recordList, nil
+}
+
+//SelectFolderGrantAll はすべての閲覧許可を返す
+func SelectFolderGrantAll(ctx context.Context) ([]FolderGrantTable, error) {
+	recordList, err := dbStore.SelectFolderGrantListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return recordList, nil
+}
+
+//DeleteFolderGrant は閲覧許可を削除する
+func DeleteFolderGrant(ctx context.Context, id int64) error {
+	if id == 0 {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.DeleteFolderGrant(ctx, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//DeleteFolderGrantOfUser は指定したユーザーに対する閲覧許可をすべて削除する
+func DeleteFolderGrantOfUser(ctx context.Context, userName string) error {
+	if userName == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.DeleteFolderGrantOfUser(ctx, userName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStore) InsertFolderGrant(ctx context.Context, record FolderGrantTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(folderGrantTableName).
+		Columns("folder_hash", "user_name", "permission", "created_at").
+		Record(record).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqlStore) SelectFolderGrantListFromUser(ctx context.Context, userName string, permission int) ([]FolderGrantTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []FolderGrantTable
+	_, err := session.Select("*").From(folderGrantTableName).
+		Where("(user_name = ? and user_name <> '') or (permission = ? and permission <> 0)", userName, permission).
+		LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectFolderGrantListAll(ctx context.Context) ([]FolderGrantTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []FolderGrantTable
+	_, err := session.Select("*").From(folderGrantTableName).OrderBy("id").LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) DeleteFolderGrant(ctx context.Context, id int64) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(folderGrantTableName).
+		Where("id = ?", id).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStore) DeleteFolderGrantOfUser(ctx context.Context, userName string) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(folderGrantTableName).
+		Where("user_name = ?", userName).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//テーブル名
+const folderRatingTableName = "folder_ratings"
+
+//FolderRatingTable フォルダ対象年齢情報テーブル
+//フォルダ以下のすべてのアーカイブに適用し、アーカイブ自体の対象年齢と大きい方を使用する
+type FolderRatingTable struct {
+	ID         int64     `db:"id"`
+	FolderHash string    `db:"folder_hash"`
+	AgeRating  int       `db:"age_rating"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+//SetFolderRating はフォルダの対象年齢を設定する（0の時は設定を削除する）
+func SetFolderRating(ctx context.Context, folderHash string, ageRating int) error {
+	if folderHash == "" || ageRating < 0 {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	record := FolderRatingTable{FolderHash: folderHash, AgeRating: ageRating, UpdatedAt: time.Now()}
+	err := dbStore.ReplaceFolderRating(ctx, record)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//SelectFolderRatingAll はすべてのフォルダの対象年齢設定を返す
+func SelectFolderRatingAll(ctx context.Context) ([]FolderRatingTable, error) {
+	recordList, err := dbStore.SelectFolderRatingListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return recordList, nil
+}
+
+func (store *sqlStore) ReplaceFolderRating(ctx context.Context, record FolderRatingTable) error {
+	session := store.conn.NewSession(nil)
+	tx, err := session.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	_, err = tx.DeleteFrom(folderRatingTableName).
+		Where("folder_hash = ?", record.FolderHash).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	if record.AgeRating > 0 {
+		_, err = tx.InsertInto(folderRatingTableName).
+			Columns("folder_hash", "age_rating", "updated_at").
+			Record(record).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *sqlStore) SelectFolderRatingListAll(ctx context.Context) ([]FolderRatingTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []FolderRatingTable
+	_, err := session.Select("*").From(folderRatingTableName).LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
@@ -1,13 +1,11 @@
 package db
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"time"
-
-	"github.com/gocraft/dbr"
-	"github.com/mryp/squidgirl-go/config"
 )
 
 //テーブル名
@@ -22,7 +20,14 @@ type FolderTable struct {
 	ModTime    time.Time `db:"mod_time"`
 }
 
-func InsertFolder(filePath string, parentHash string, modTime time.Time) error {
+//FolderScanResult はフォルダ1件分の探索結果を保持する
+type FolderScanResult struct {
+	Folder     FolderTable //ModTimeがゼロの時はフォルダの更新日時を設定しない
+	InsertList []BookTable //新規登録するアーカイブ情報（NewBookRecordで生成したもの）
+	UpdateList []BookTable //更新・移動したアーカイブ情報（すべての項目を更新する）
+}
+
+func InsertFolder(ctx context.Context, filePath string, parentHash string, modTime time.Time) error {
 	fmt.Printf("InsertFolder filePath=%s, modTime=%s\n", filePath, modTime)
 	if filePath == "" {
 		return fmt.Errorf("パラメーターエラー")
@@ -30,7 +35,7 @@ func InsertFolder(filePath string, parentHash string, modTime time.Time) error {
 
 	hash := CreateFolderHash(filePath)
 	record := FolderTable{Hash: hash, ParentHash: parentHash, FilePath: filePath, ModTime: modTime}
-	err := insertFolder(nil, record)
+	err := dbStore.InsertFolder(ctx, record)
 	if err != nil {
 		fmt.Printf("InsertFolder err=%s\n", err)
 		return err
@@ -38,7 +43,7 @@ func InsertFolder(filePath string, parentHash string, modTime time.Time) error {
 	return nil
 }
 
-func UpdateFolder(filePath string, parentHash string, modTime time.Time) error {
+func UpdateFolder(ctx context.Context, filePath string, parentHash string, modTime time.Time) error {
 	fmt.Printf("UpdateFolder filePath=%s, modTime=%s\n", filePath, modTime)
 	if filePath == "" {
 		return fmt.Errorf("パラメーターエラー")
@@ -46,7 +51,7 @@ func UpdateFolder(filePath string, parentHash string, modTime time.Time) error {
 
 	hash := CreateFolderHash(filePath)
 	record := FolderTable{Hash: hash, ParentHash: parentHash, FilePath: filePath, ModTime: modTime}
-	err := updateFolder(nil, record)
+	err := dbStore.UpdateFolder(ctx, record)
 	if err != nil {
 		fmt.Printf("UpdateFolder err=%s\n", err)
 		return err
@@ -54,10 +59,43 @@ func UpdateFolder(filePath string, parentHash string, modTime time.Time) error {
 	return nil
 }
 
-func DeleteFolder(id int64) error {
+//UpdateFolderParent はフォルダ情報の親フォルダを更新する
+func UpdateFolderParent(ctx context.Context, hash string, parentHash string) error {
+	fmt.Printf("UpdateFolderParent hash=%s, parentHash=%s\n", hash, parentHash)
+	if hash == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	record := FolderTable{Hash: hash, ParentHash: parentHash}
+	err := dbStore.UpdateFolderParent(ctx, record)
+	if err != nil {
+		fmt.Printf("UpdateFolderParent err=%s\n", err)
+		return err
+	}
+	return nil
+}
+
+//ApplyFolderScanResult はフォルダ1件分の探索結果を1つのトランザクションでまとめて反映する
+func ApplyFolderScanResult(ctx context.Context, result FolderScanResult) error {
+	fmt.Printf("ApplyFolderScanResult filePath=%s, insert=%d, update=%d, modTime=%s\n",
+		result.Folder.FilePath, len(result.InsertList), len(result.UpdateList), result.Folder.ModTime)
+	if result.Folder.Hash == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.ApplyFolderScanResult(ctx, result)
+	if err != nil {
+		fmt.Printf("ApplyFolderScanResult err=%s\n", err)
+		return err
+	}
+	return nil
+}
+
+//DeleteFolder はフォルダ情報を削除し、フォルダ内のアーカイブ情報を削除済みにする（サブフォルダは削除しない）
+func DeleteFolder(ctx context.Context, id int64) error {
 	fmt.Printf("DeleteFolder id=%d\n", id)
 
-	err := deleteFolder(nil, id)
+	err := dbStore.DeleteFolderList(ctx, []int64{id})
 	if err != nil {
 		fmt.Printf("DeleteFolder err=%s\n", err)
 		return err
@@ -65,11 +103,29 @@ func DeleteFolder(id int64) error {
 	return nil
 }
 
-func SelectFolder(filePath string) (FolderTable, error) {
+//DeleteFolderList は複数のフォルダ情報をまとめて削除し、それらのフォルダ内のアーカイブ情報を削除済みにする（サブフォルダは削除しない）
+//フォルダのハッシュはパスから決まるため、フォルダが再び見つかった時は削除済みのアーカイブ情報をそのまま復元できる
+func DeleteFolderList(ctx context.Context, idList []int64) error {
+	fmt.Printf("DeleteFolderList len=%d\n", len(idList))
+	if len(idList) == 0 {
+		return nil
+	}
+
+	err := execBatch(len(idList), func(start int, end int) error {
+		return dbStore.DeleteFolderList(ctx, idList[start:end])
+	})
+	if err != nil {
+		fmt.Printf("DeleteFolderList err=%s\n", err)
+		return err
+	}
+	return nil
+}
+
+func SelectFolder(ctx context.Context, filePath string) (FolderTable, error) {
 	fmt.Printf("SelectFolder filePath=%s\n", filePath)
 	var result FolderTable
 	hash := CreateFolderHash(filePath)
-	recordList, err := selectFolderList(nil, hash)
+	recordList, err := dbStore.SelectFolderList(ctx, hash)
 	if err != nil {
 		fmt.Printf("SelectFolder err=%s\n", err)
 		return result, err
@@ -82,10 +138,10 @@ func SelectFolder(filePath string) (FolderTable, error) {
 	return recordList[0], nil
 }
 
-func SelectFolderFromHash(hash string) (FolderTable, error) {
+func SelectFolderFromHash(ctx context.Context, hash string) (FolderTable, error) {
 	fmt.Printf("SelectFolderFromHash hash=%s\n", hash)
 	var result FolderTable
-	recordList, err := selectFolderList(nil, hash)
+	recordList, err := dbStore.SelectFolderList(ctx, hash)
 	if err != nil {
 		fmt.Printf("SelectFolderFromHash err=%s\n", err)
 		return result, err
@@ -98,9 +154,9 @@ func SelectFolderFromHash(hash string) (FolderTable, error) {
 	return recordList[0], nil
 }
 
-func SelectFolderListFromParent(parentHash string) ([]FolderTable, error) {
+func SelectFolderListFromParent(ctx context.Context, parentHash string) ([]FolderTable, error) {
 	fmt.Printf("SelectFolderListFromParent parentHash=%s\n", parentHash)
-	recordList, err := selectFolderListFromParent(nil, parentHash)
+	recordList, err := dbStore.SelectFolderListFromParent(ctx, parentHash)
 	if err != nil {
 		fmt.Printf("SelectFolderListFromParent err=%s\n", err)
 		return nil, err
@@ -109,14 +165,15 @@ func SelectFolderListFromParent(parentHash string) ([]FolderTable, error) {
 	return recordList, nil
 }
 
-func SelectFolderRoot() (FolderTable, error) {
-	fmt.Printf("SelectFolderRoot\n")
-	return SelectFolder(config.GetConfig().File.WatchDir)
+//SelectFolderRoot は指定したライブラリのルートフォルダ情報を取得する
+func SelectFolderRoot(ctx context.Context, rootPath string) (FolderTable, error) {
+	fmt.Printf("SelectFolderRoot rootPath=%s\n", rootPath)
+	return SelectFolder(ctx, rootPath)
 }
 
-func SelectFolderAll() ([]FolderTable, error) {
+func SelectFolderAll(ctx context.Context) ([]FolderTable, error) {
 	fmt.Printf("SelectFolderAll\n")
-	recordList, err := selectFolderListAll(nil)
+	recordList, err := dbStore.SelectFolderListAll(ctx)
 	if err != nil {
 		fmt.Printf("SelectFolderAll err=%s\n", err)
 		return nil, err
@@ -129,17 +186,12 @@ func SelectFolderAll() ([]FolderTable, error) {
 	return recordList, nil
 }
 
-func insertFolder(session *dbr.Session, record FolderTable) error {
-	session, err := ConnectDBRecheck(session)
-	if err != nil {
-		return nil
-	}
-	defer session.Close()
-
-	_, err = session.InsertInto(folderTableName).
+func (store *sqlStore) InsertFolder(ctx context.Context, record FolderTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(folderTableName).
 		Columns("hash", "parent_hash", "file_path", "mod_time").
 		Record(record).
-		Exec()
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -147,63 +199,90 @@ func insertFolder(session *dbr.Session, record FolderTable) error {
 	return nil
 }
 
-func updateFolder(session *dbr.Session, record FolderTable) error {
-	session, err := ConnectDBRecheck(session)
-	if err != nil {
-		return nil
-	}
-	defer session.Close()
-
-	_, err = session.Update(folderTableName).
+func (store *sqlStore) UpdateFolder(ctx context.Context, record FolderTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(folderTableName).
 		Set("mod_time", record.ModTime).
 		Where("hash = ?", record.Hash).
-		Exec()
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func deleteFolder(session *dbr.Session, id int64) error {
-	session, err := ConnectDBRecheck(session)
-	if err != nil {
-		return nil
-	}
-	defer session.Close()
-	_, err = session.DeleteFrom(folderTableName).
-		Where("id = ?", id).
-		Exec()
+func (store *sqlStore) UpdateFolderParent(ctx context.Context, record FolderTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(folderTableName).
+		Set("parent_hash", record.ParentHash).
+		Where("hash = ?", record.Hash).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func selectFolderList(session *dbr.Session, hash string) ([]FolderTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) ApplyFolderScanResult(ctx context.Context, result FolderScanResult) error {
+	session := store.conn.NewSession(nil)
+	tx, err := session.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer session.Close()
+	defer tx.RollbackUnlessCommitted()
 
-	var resultList []FolderTable
-	_, err = session.Select("*").From(folderTableName).Where("hash = ?", hash).Load(&resultList)
+	err = execBatch(len(result.InsertList), func(start int, end int) error {
+		return insertBookList(ctx, tx, result.InsertList[start:end])
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return resultList, nil
+	if err := updateBookList(ctx, tx, result.UpdateList); err != nil {
+		return err
+	}
+	if !result.Folder.ModTime.IsZero() {
+		_, err := tx.Update(folderTableName).
+			Set("mod_time", result.Folder.ModTime).
+			Where("hash = ?", result.Folder.Hash).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func selectFolderListFromParent(session *dbr.Session, parentHash string) ([]FolderTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) DeleteFolderList(ctx context.Context, idList []int64) error {
+	session := store.conn.NewSession(nil)
+	tx, err := session.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	//フォルダ内のアーカイブを削除済みにしてからフォルダを削除する
+	folderQuery := "SELECT hash FROM " + folderTableName + " WHERE id IN ?"
+	_, err = tx.Update(bookTableName).
+		Set("deleted", true).
+		Set("deleted_at", time.Now()).
+		Where("folder_hash IN ("+folderQuery+") AND deleted = ?", idList, false).
+		ExecContext(ctx)
+	if err != nil {
+		return err
 	}
-	defer session.Close()
+	_, err = tx.DeleteFrom(folderTableName).
+		Where("id IN ?", idList).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
+func (store *sqlStore) SelectFolderList(ctx context.Context, hash string) ([]FolderTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []FolderTable
-	_, err = session.Select("*").From(folderTableName).Where("parent_hash = ?", parentHash).Load(&resultList)
+	_, err := session.Select("*").From(folderTableName).Where("hash = ?", hash).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -211,15 +290,21 @@ func selectFolderListFromParent(session *dbr.Session, parentHash string) ([]Fold
 	return resultList, nil
 }
 
-func selectFolderListAll(session *dbr.Session) ([]FolderTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) SelectFolderListFromParent(ctx context.Context, parentHash string) ([]FolderTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []FolderTable
+	_, err := session.Select("*").From(folderTableName).Where("parent_hash = ?", parentHash).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
 
+	return resultList, nil
+}
+
+func (store *sqlStore) SelectFolderListAll(ctx context.Context) ([]FolderTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []FolderTable
-	_, err = session.Select("*").From(folderTableName).Load(&resultList)
+	_, err := session.Select("*").From(folderTableName).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
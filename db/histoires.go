@@ -1,10 +1,10 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
-
-	"github.com/gocraft/dbr"
 )
 
 //テーブル名
@@ -20,12 +20,24 @@ type HistoryTable struct {
 	ModTime  time.Time `db:"mod_time"`
 }
 
-func InsertHistory(userName string, bookHash string, readPos int, reaction int, isExistUpdate bool) error {
+func InsertHistory(ctx context.Context, userName string, bookHash string, readPos int, reaction int, isExistUpdate bool) error {
 	fmt.Printf("InsertHistory userName=%s, bookHash=%s, readPos=%d, reaction=%d isExistUpdate=%v\n",
 		userName, bookHash, readPos, reaction, isExistUpdate)
 	if userName == "" || bookHash == "" {
 		return fmt.Errorf("パラメーターエラー")
 	}
+
+	if isExistUpdate {
+		//データが存在するときは更新する（未指定(-1)の項目は既存の値のまま）
+		record := HistoryTable{UserName: userName, BookHash: bookHash, ReadPos: readPos, Reaction: reaction, ModTime: time.Now()}
+		err := dbStore.UpsertHistory(ctx, record)
+		if err != nil {
+			fmt.Printf("InsertHistory upsert err=%s\n", err)
+			return err
+		}
+		return nil
+	}
+
 	//未指定が指定されたときは初期値を設定
 	if readPos == -1 {
 		readPos = 0
@@ -33,17 +45,8 @@ func InsertHistory(userName string, bookHash string, readPos int, reaction int,
 	if reaction == -1 {
 		reaction = 0
 	}
-
-	if isExistUpdate {
-		//データが存在するときは更新する
-		history, _ := SelectHistory(userName, bookHash)
-		if history.BookHash != "" {
-			return UpdateHistory(userName, bookHash, readPos, reaction)
-		}
-	}
-
 	record := HistoryTable{UserName: userName, BookHash: bookHash, ReadPos: readPos, Reaction: reaction, ModTime: time.Now()}
-	err := insertHistory(nil, record)
+	err := dbStore.InsertHistory(ctx, record)
 	if err != nil {
 		fmt.Printf("insertHistory err=%s\n", err)
 		return err
@@ -51,14 +54,14 @@ func InsertHistory(userName string, bookHash string, readPos int, reaction int,
 	return nil
 }
 
-func UpdateHistory(userName string, bookHash string, readPos int, reaction int) error {
+func UpdateHistory(ctx context.Context, userName string, bookHash string, readPos int, reaction int) error {
 	fmt.Printf("UpdateHistory userName=%s, bookHash=%s, readPos=%d, reaction=%d\n", userName, bookHash, readPos, reaction)
 	if userName == "" || bookHash == "" {
 		return fmt.Errorf("パラメーターエラー")
 	}
 
 	record := HistoryTable{UserName: userName, BookHash: bookHash, ReadPos: readPos, Reaction: reaction, ModTime: time.Now()}
-	err := updateHistory(nil, record)
+	err := dbStore.UpdateHistory(ctx, record)
 	if err != nil {
 		fmt.Printf("UpdateHistory err=%s\n", err)
 		return err
@@ -66,10 +69,10 @@ func UpdateHistory(userName string, bookHash string, readPos int, reaction int)
 	return nil
 }
 
-func SelectHistory(userName string, bookHash string) (HistoryTable, error) {
+func SelectHistory(ctx context.Context, userName string, bookHash string) (HistoryTable, error) {
 	fmt.Printf("SelectHistory userName=%s, bookHash=%s\n", userName, bookHash)
 	var result HistoryTable
-	recordList, err := selectHistoryList(nil, userName, bookHash)
+	recordList, err := dbStore.SelectHistoryList(ctx, userName, bookHash)
 	if err != nil {
 		fmt.Printf("SelectHistory err=%s\n", err)
 		return result, err
@@ -82,13 +85,13 @@ func SelectHistory(userName string, bookHash string) (HistoryTable, error) {
 	return recordList[0], nil
 }
 
-func DeleteHistory(userName string) error {
+func DeleteHistory(ctx context.Context, userName string) error {
 	fmt.Printf("DeleteHistory userName=%s\n", userName)
 	if userName == "" {
 		return fmt.Errorf("パラメーターエラー")
 	}
 
-	err := deleteHistory(nil, userName)
+	err := dbStore.DeleteHistory(ctx, userName)
 	if err != nil {
 		fmt.Printf("DeleteHistory err=%s\n", err)
 		return err
@@ -96,17 +99,41 @@ func DeleteHistory(userName string) error {
 	return nil
 }
 
-func insertHistory(session *dbr.Session, record HistoryTable) error {
-	session, err := ConnectDBRecheck(session)
+//SelectHistoryAll はすべてのユーザーの履歴を取得する
+func SelectHistoryAll(ctx context.Context) ([]HistoryTable, error) {
+	fmt.Printf("SelectHistoryAll\n")
+	recordList, err := dbStore.SelectHistoryListAll(ctx)
 	if err != nil {
+		fmt.Printf("SelectHistoryAll err=%s\n", err)
+		return nil, err
+	}
+
+	return recordList, nil
+}
+
+//DeleteHistoryList は複数の履歴をまとめて削除する
+func DeleteHistoryList(ctx context.Context, idList []int64) error {
+	fmt.Printf("DeleteHistoryList len=%d\n", len(idList))
+	if len(idList) == 0 {
 		return nil
 	}
-	defer session.Close()
 
-	_, err = session.InsertInto(historyTableName).
+	err := execBatch(len(idList), func(start int, end int) error {
+		return dbStore.DeleteHistoryList(ctx, idList[start:end])
+	})
+	if err != nil {
+		fmt.Printf("DeleteHistoryList err=%s\n", err)
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStore) InsertHistory(ctx context.Context, record HistoryTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(historyTableName).
 		Columns("user_name", "book_hash", "read_pos", "reaction", "mod_time").
 		Record(record).
-		Exec()
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -114,13 +141,52 @@ func insertHistory(session *dbr.Session, record HistoryTable) error {
 	return nil
 }
 
-func updateHistory(session *dbr.Session, record HistoryTable) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) UpsertHistory(ctx context.Context, record HistoryTable) error {
+	//新規登録時は未指定(-1)を初期値にする
+	readPos := record.ReadPos
+	if readPos == -1 {
+		readPos = 0
+	}
+	reaction := record.Reaction
+	if reaction == -1 {
+		reaction = 0
+	}
+
+	//更新時は指定された項目だけを上書きする
+	updateColumns := []string{"mod_time"}
+	if record.ReadPos != -1 {
+		updateColumns = append(updateColumns, "read_pos")
+	}
+	if record.Reaction != -1 {
+		updateColumns = append(updateColumns, "reaction")
+	}
+	setList := make([]string, 0)
+	for _, column := range updateColumns {
+		if store.driver == DriverSQLite {
+			setList = append(setList, column+" = excluded."+column)
+		} else {
+			setList = append(setList, column+" = VALUES("+column+")")
+		}
+	}
+
+	query := "INSERT INTO " + historyTableName + " (user_name, book_hash, read_pos, reaction, mod_time) VALUES (?, ?, ?, ?, ?) "
+	if store.driver == DriverSQLite {
+		query += "ON CONFLICT (user_name, book_hash) DO UPDATE SET " + strings.Join(setList, ", ")
+	} else {
+		query += "ON DUPLICATE KEY UPDATE " + strings.Join(setList, ", ")
+	}
+
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertBySql(query, record.UserName, record.BookHash, readPos, reaction, record.ModTime).
+		ExecContext(ctx)
 	if err != nil {
-		return nil
+		return err
 	}
-	defer session.Close()
+	return nil
+}
 
+func (store *sqlStore) UpdateHistory(ctx context.Context, record HistoryTable) error {
+	session := store.conn.NewSession(nil)
 	builder := session.Update(historyTableName)
 	if record.ReadPos != -1 {
 		builder = builder.Set("read_pos", record.ReadPos)
@@ -128,27 +194,22 @@ func updateHistory(session *dbr.Session, record HistoryTable) error {
 	if record.Reaction != -1 {
 		builder = builder.Set("reaction", record.Reaction)
 	}
-	_, err = builder.Set("mod_time", record.ModTime).
+	_, err := builder.Set("mod_time", record.ModTime).
 		Where("user_name = ? AND book_hash = ?", record.UserName, record.BookHash).
-		Exec()
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func selectHistoryList(session *dbr.Session, userName string, bookHash string) ([]HistoryTable, error) {
-	session, err := ConnectDBRecheck(session)
-	if err != nil {
-		return nil, err
-	}
-	defer session.Close()
-
+func (store *sqlStore) SelectHistoryList(ctx context.Context, userName string, bookHash string) ([]HistoryTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []HistoryTable
-	_, err = session.Select("*").
+	_, err := session.Select("*").
 		From(historyTableName).
 		Where("user_name = ? AND book_hash = ?", userName, bookHash).
-		Load(&resultList)
+		LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -156,16 +217,33 @@ func selectHistoryList(session *dbr.Session, userName string, bookHash string) (
 	return resultList, nil
 }
 
-func deleteHistory(session *dbr.Session, userName string) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) DeleteHistory(ctx context.Context, userName string) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(historyTableName).
+		Where("user_name = ?", userName).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	return nil
+}
 
-	_, err = session.DeleteFrom(historyTableName).
-		Where("user_name = ?", userName).
-		Exec()
+func (store *sqlStore) SelectHistoryListAll(ctx context.Context) ([]HistoryTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []HistoryTable
+	_, err := session.Select("*").From(historyTableName).LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) DeleteHistoryList(ctx context.Context, idList []int64) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(historyTableName).
+		Where("id IN ?", idList).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,715 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//memoryStore はメモリ上をデータ保存先とする（テスト・デモ用で終了時にすべて破棄される）
+type memoryStore struct {
+	mutex     *sync.RWMutex
+	lastID    int64
+	books     []BookTable
+	folders   []FolderTable
+	histoires []HistoryTable
+	users     []UserTable
+	tokens    []RefreshTokenTable
+	grants    []FolderGrantTable
+	ratings   []FolderRatingTable
+	apiKeys   []APIKeyTable
+}
+
+//NewMemoryStore はメモリ上をデータ保存先として生成して返す
+func NewMemoryStore() Store {
+	store := new(memoryStore)
+	store.mutex = new(sync.RWMutex)
+	return store
+}
+
+//nextID は自動採番したIDを返す（ロックした状態で呼び出すこと）
+func (store *memoryStore) nextID() int64 {
+	store.lastID++
+	return store.lastID
+}
+
+func (store *memoryStore) Close() error {
+	return nil
+}
+
+func (store *memoryStore) InsertBook(ctx context.Context, record BookTable) error {
+	return store.InsertBookList(ctx, []BookTable{record})
+}
+
+func (store *memoryStore) InsertBookList(ctx context.Context, recordList []BookTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.insertBookList(recordList)
+}
+
+//insertBookList は複数のアーカイブ情報を登録する（ロックした状態で呼び出すこと）
+//重複データがある時は1件も登録しない
+func (store *memoryStore) insertBookList(recordList []BookTable) error {
+	hashMap := make(map[string]bool)
+	for _, v := range store.books {
+		hashMap[v.Hash] = true
+	}
+	for _, record := range recordList {
+		if hashMap[record.Hash] {
+			return fmt.Errorf("重複データ hash=%s", record.Hash)
+		}
+		hashMap[record.Hash] = true
+	}
+	for _, record := range recordList {
+		record.ID = store.nextID()
+		store.books = append(store.books, record)
+	}
+	return nil
+}
+
+func (store *memoryStore) UpdateBookList(ctx context.Context, recordList []BookTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.updateBookList(recordList)
+	return nil
+}
+
+//updateBookList は複数のアーカイブ情報（ID以外のすべての項目）を更新する（ロックした状態で呼び出すこと）
+func (store *memoryStore) updateBookList(recordList []BookTable) {
+	for _, record := range recordList {
+		for i, v := range store.books {
+			if v.Hash == record.Hash {
+				record.ID = v.ID
+				store.books[i] = record
+			}
+		}
+	}
+}
+
+func (store *memoryStore) SoftDeleteBookList(ctx context.Context, idList []int64, deletedAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	idMap := make(map[int64]bool)
+	for _, id := range idList {
+		idMap[id] = true
+	}
+	store.softDeleteBookList(func(v BookTable) bool { return idMap[v.ID] }, deletedAt)
+	return nil
+}
+
+//softDeleteBookList は条件に一致する削除済みでないアーカイブ情報を削除済みにする（ロックした状態で呼び出すこと）
+func (store *memoryStore) softDeleteBookList(match func(BookTable) bool, deletedAt time.Time) {
+	for i, v := range store.books {
+		if !v.Deleted && match(v) {
+			store.books[i].Deleted = true
+			store.books[i].DeletedAt = deletedAt
+		}
+	}
+}
+
+func (store *memoryStore) DeleteBookList(ctx context.Context, idList []int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	idMap := make(map[int64]bool)
+	for _, id := range idList {
+		idMap[id] = true
+	}
+	store.deleteBookList(func(v BookTable) bool { return idMap[v.ID] })
+	return nil
+}
+
+//deleteBookList は条件に一致するアーカイブ情報と、そのアーカイブの履歴を削除する（ロックした状態で呼び出すこと）
+func (store *memoryStore) deleteBookList(match func(BookTable) bool) {
+	hashMap := make(map[string]bool)
+	resultList := store.books[:0]
+	for _, v := range store.books {
+		if match(v) {
+			hashMap[v.Hash] = true
+		} else {
+			resultList = append(resultList, v)
+		}
+	}
+	store.books = resultList
+
+	historyList := store.histoires[:0]
+	for _, v := range store.histoires {
+		if !hashMap[v.BookHash] {
+			historyList = append(historyList, v)
+		}
+	}
+	store.histoires = historyList
+}
+
+func (store *memoryStore) SelectBookList(ctx context.Context, hash string) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return v.Hash == hash }), nil
+}
+
+func (store *memoryStore) SelectBookListFromPath(ctx context.Context, filePath string) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return v.FilePath == filePath }), nil
+}
+
+func (store *memoryStore) SelectBookListFromFingerprint(ctx context.Context, fingerprint string) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return v.Fingerprint == fingerprint }), nil
+}
+
+func (store *memoryStore) SelectBookListFromFolder(ctx context.Context, folderHash string) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return v.FolderHash == folderHash }), nil
+}
+
+func (store *memoryStore) SelectBookListFromStatus(ctx context.Context, status int) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return v.Status == status }), nil
+}
+
+func (store *memoryStore) SelectBookListFromDeleted(ctx context.Context, before time.Time) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return v.Deleted && v.DeletedAt.Before(before) }), nil
+}
+
+func (store *memoryStore) SelectBookListAll(ctx context.Context) ([]BookTable, error) {
+	return store.selectBookList(func(v BookTable) bool { return true }), nil
+}
+
+//selectBookList は条件に一致するアーカイブ情報をコピーして返す
+func (store *memoryStore) selectBookList(match func(BookTable) bool) []BookTable {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]BookTable, 0)
+	for _, v := range store.books {
+		if match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList
+}
+
+func (store *memoryStore) InsertFolder(ctx context.Context, record FolderTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, v := range store.folders {
+		if v.Hash == record.Hash {
+			return fmt.Errorf("重複データ hash=%s", record.Hash)
+		}
+	}
+	record.ID = store.nextID()
+	store.folders = append(store.folders, record)
+	return nil
+}
+
+func (store *memoryStore) UpdateFolder(ctx context.Context, record FolderTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for i, v := range store.folders {
+		if v.Hash == record.Hash {
+			store.folders[i].ModTime = record.ModTime
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) UpdateFolderParent(ctx context.Context, record FolderTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for i, v := range store.folders {
+		if v.Hash == record.Hash {
+			store.folders[i].ParentHash = record.ParentHash
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) ApplyFolderScanResult(ctx context.Context, result FolderScanResult) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.insertBookList(result.InsertList); err != nil {
+		return err
+	}
+	store.updateBookList(result.UpdateList)
+	if !result.Folder.ModTime.IsZero() {
+		for i, v := range store.folders {
+			if v.Hash == result.Folder.Hash {
+				store.folders[i].ModTime = result.Folder.ModTime
+			}
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) DeleteFolderList(ctx context.Context, idList []int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	idMap := make(map[int64]bool)
+	for _, id := range idList {
+		idMap[id] = true
+	}
+	hashMap := make(map[string]bool)
+	resultList := store.folders[:0]
+	for _, v := range store.folders {
+		if idMap[v.ID] {
+			hashMap[v.Hash] = true
+		} else {
+			resultList = append(resultList, v)
+		}
+	}
+	store.folders = resultList
+	store.softDeleteBookList(func(v BookTable) bool { return hashMap[v.FolderHash] }, time.Now())
+	return nil
+}
+
+func (store *memoryStore) SelectFolderList(ctx context.Context, hash string) ([]FolderTable, error) {
+	return store.selectFolderList(func(v FolderTable) bool { return v.Hash == hash }), nil
+}
+
+func (store *memoryStore) SelectFolderListFromParent(ctx context.Context, parentHash string) ([]FolderTable, error) {
+	return store.selectFolderList(func(v FolderTable) bool { return v.ParentHash == parentHash }), nil
+}
+
+func (store *memoryStore) SelectFolderListAll(ctx context.Context) ([]FolderTable, error) {
+	return store.selectFolderList(func(v FolderTable) bool { return true }), nil
+}
+
+//selectFolderList は条件に一致するフォルダ情報をコピーして返す
+func (store *memoryStore) selectFolderList(match func(FolderTable) bool) []FolderTable {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]FolderTable, 0)
+	for _, v := range store.folders {
+		if match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList
+}
+
+func (store *memoryStore) InsertHistory(ctx context.Context, record HistoryTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, v := range store.histoires {
+		if v.UserName == record.UserName && v.BookHash == record.BookHash {
+			return fmt.Errorf("重複データ userName=%s, bookHash=%s", record.UserName, record.BookHash)
+		}
+	}
+	record.ID = store.nextID()
+	store.histoires = append(store.histoires, record)
+	return nil
+}
+
+func (store *memoryStore) UpdateHistory(ctx context.Context, record HistoryTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.updateHistory(record)
+	return nil
+}
+
+func (store *memoryStore) UpsertHistory(ctx context.Context, record HistoryTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.updateHistory(record) {
+		return nil
+	}
+	if record.ReadPos == -1 {
+		record.ReadPos = 0
+	}
+	if record.Reaction == -1 {
+		record.Reaction = 0
+	}
+	record.ID = store.nextID()
+	store.histoires = append(store.histoires, record)
+	return nil
+}
+
+//updateHistory は既存の履歴を更新し、更新対象があったかどうかを返す（ロックした状態で呼び出すこと）
+func (store *memoryStore) updateHistory(record HistoryTable) bool {
+	updated := false
+	for i, v := range store.histoires {
+		if v.UserName != record.UserName || v.BookHash != record.BookHash {
+			continue
+		}
+		if record.ReadPos != -1 {
+			store.histoires[i].ReadPos = record.ReadPos
+		}
+		if record.Reaction != -1 {
+			store.histoires[i].Reaction = record.Reaction
+		}
+		store.histoires[i].ModTime = record.ModTime
+		updated = true
+	}
+	return updated
+}
+
+func (store *memoryStore) SelectHistoryList(ctx context.Context, userName string, bookHash string) ([]HistoryTable, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]HistoryTable, 0)
+	for _, v := range store.histoires {
+		if v.UserName == userName && v.BookHash == bookHash {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList, nil
+}
+
+func (store *memoryStore) SelectHistoryListAll(ctx context.Context) ([]HistoryTable, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]HistoryTable, len(store.histoires))
+	copy(resultList, store.histoires)
+	return resultList, nil
+}
+
+func (store *memoryStore) DeleteHistoryList(ctx context.Context, idList []int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	idMap := make(map[int64]bool)
+	for _, id := range idList {
+		idMap[id] = true
+	}
+	resultList := store.histoires[:0]
+	for _, v := range store.histoires {
+		if !idMap[v.ID] {
+			resultList = append(resultList, v)
+		}
+	}
+	store.histoires = resultList
+	return nil
+}
+
+func (store *memoryStore) DeleteHistory(ctx context.Context, userName string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	resultList := store.histoires[:0]
+	for _, v := range store.histoires {
+		if v.UserName != userName {
+			resultList = append(resultList, v)
+		}
+	}
+	store.histoires = resultList
+	return nil
+}
+
+func (store *memoryStore) InsertUser(ctx context.Context, record UserTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, v := range store.users {
+		if v.Name == record.Name {
+			return fmt.Errorf("重複データ name=%s", record.Name)
+		}
+	}
+	record.ID = store.nextID()
+	store.users = append(store.users, record)
+	return nil
+}
+
+func (store *memoryStore) UpdateUser(ctx context.Context, record UserTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for i, v := range store.users {
+		if v.Name == record.Name {
+			store.users[i].PassHash = record.PassHash
+			store.users[i].Permission = record.Permission
+			store.users[i].UpdatedAt = record.UpdatedAt
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) UpdateUserPassword(ctx context.Context, record UserTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for i, v := range store.users {
+		if v.Name == record.Name {
+			store.users[i].PassHash = record.PassHash
+			store.users[i].MustChangePassword = record.MustChangePassword
+			store.users[i].UpdatedAt = record.UpdatedAt
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) UpdateUserMaxAgeRating(ctx context.Context, record UserTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for i, v := range store.users {
+		if v.Name == record.Name {
+			store.users[i].MaxAgeRating = record.MaxAgeRating
+			store.users[i].UpdatedAt = record.UpdatedAt
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) UpdateUserTokenVersion(ctx context.Context, name string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for i, v := range store.users {
+		if v.Name == name {
+			store.users[i].TokenVersion++
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) SelectUserList(ctx context.Context, name string) ([]UserTable, error) {
+	return store.selectUserList(func(v UserTable) bool { return v.Name == name }), nil
+}
+
+func (store *memoryStore) SelectUserListAll(ctx context.Context) ([]UserTable, error) {
+	return store.selectUserList(func(v UserTable) bool { return true }), nil
+}
+
+//selectUserList は条件に一致するユーザー情報をコピーして返す
+func (store *memoryStore) selectUserList(match func(UserTable) bool) []UserTable {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]UserTable, 0)
+	for _, v := range store.users {
+		if match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList
+}
+
+func (store *memoryStore) DeleteUser(ctx context.Context, id int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	resultList := store.users[:0]
+	for _, v := range store.users {
+		if v.ID != id {
+			resultList = append(resultList, v)
+		}
+	}
+	store.users = resultList
+	return nil
+}
+
+func (store *memoryStore) InsertRefreshToken(ctx context.Context, record RefreshTokenTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, v := range store.tokens {
+		if v.TokenHash == record.TokenHash {
+			return fmt.Errorf("重複データ tokenHash=%s", record.TokenHash)
+		}
+	}
+	record.ID = store.nextID()
+	store.tokens = append(store.tokens, record)
+	return nil
+}
+
+func (store *memoryStore) SelectRefreshTokenList(ctx context.Context, tokenHash string) ([]RefreshTokenTable, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]RefreshTokenTable, 0)
+	for _, v := range store.tokens {
+		if v.TokenHash == tokenHash {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList, nil
+}
+
+func (store *memoryStore) DeleteRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	return store.deleteRefreshToken(func(v RefreshTokenTable) bool { return v.TokenHash == tokenHash }), nil
+}
+
+func (store *memoryStore) DeleteRefreshTokenOfUser(ctx context.Context, userName string) error {
+	store.deleteRefreshToken(func(v RefreshTokenTable) bool { return v.UserName == userName })
+	return nil
+}
+
+func (store *memoryStore) DeleteRefreshTokenExpired(ctx context.Context, now time.Time) error {
+	store.deleteRefreshToken(func(v RefreshTokenTable) bool { return v.ExpiresAt.Before(now) })
+	return nil
+}
+
+//deleteRefreshToken は条件に一致するリフレッシュトークンを削除し、削除した件数を返す
+func (store *memoryStore) deleteRefreshToken(match func(RefreshTokenTable) bool) int64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	resultList := store.tokens[:0]
+	for _, v := range store.tokens {
+		if !match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	count := int64(len(store.tokens) - len(resultList))
+	store.tokens = resultList
+	return count
+}
+
+func (store *memoryStore) InsertFolderGrant(ctx context.Context, record FolderGrantTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record.ID = store.nextID()
+	store.grants = append(store.grants, record)
+	return nil
+}
+
+func (store *memoryStore) SelectFolderGrantListFromUser(ctx context.Context, userName string, permission int) ([]FolderGrantTable, error) {
+	return store.selectFolderGrantList(func(v FolderGrantTable) bool {
+		return (v.UserName != "" && v.UserName == userName) || (v.Permission != 0 && v.Permission == permission)
+	}), nil
+}
+
+func (store *memoryStore) SelectFolderGrantListAll(ctx context.Context) ([]FolderGrantTable, error) {
+	return store.selectFolderGrantList(func(v FolderGrantTable) bool { return true }), nil
+}
+
+//selectFolderGrantList は条件に一致する閲覧許可をコピーして返す
+func (store *memoryStore) selectFolderGrantList(match func(FolderGrantTable) bool) []FolderGrantTable {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]FolderGrantTable, 0)
+	for _, v := range store.grants {
+		if match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList
+}
+
+func (store *memoryStore) DeleteFolderGrant(ctx context.Context, id int64) error {
+	return store.deleteFolderGrant(func(v FolderGrantTable) bool { return v.ID == id })
+}
+
+func (store *memoryStore) DeleteFolderGrantOfUser(ctx context.Context, userName string) error {
+	return store.deleteFolderGrant(func(v FolderGrantTable) bool { return v.UserName == userName })
+}
+
+//deleteFolderGrant は条件に一致する閲覧許可を削除する
+func (store *memoryStore) deleteFolderGrant(match func(FolderGrantTable) bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	resultList := store.grants[:0]
+	for _, v := range store.grants {
+		if !match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	store.grants = resultList
+	return nil
+}
+
+func (store *memoryStore) ReplaceFolderRating(ctx context.Context, record FolderRatingTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	resultList := store.ratings[:0]
+	for _, v := range store.ratings {
+		if v.FolderHash != record.FolderHash {
+			resultList = append(resultList, v)
+		}
+	}
+	if record.AgeRating > 0 {
+		record.ID = store.nextID()
+		resultList = append(resultList, record)
+	}
+	store.ratings = resultList
+	return nil
+}
+
+func (store *memoryStore) SelectFolderRatingListAll(ctx context.Context) ([]FolderRatingTable, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]FolderRatingTable, len(store.ratings))
+	copy(resultList, store.ratings)
+	return resultList, nil
+}
+
+func (store *memoryStore) InsertAPIKey(ctx context.Context, record APIKeyTable) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, v := range store.apiKeys {
+		if v.KeyHash == record.KeyHash {
+			return fmt.Errorf("重複データ keyHash=%s", record.KeyHash)
+		}
+	}
+	record.ID = store.nextID()
+	store.apiKeys = append(store.apiKeys, record)
+	return nil
+}
+
+func (store *memoryStore) SelectAPIKeyList(ctx context.Context, keyHash string) ([]APIKeyTable, error) {
+	return store.selectAPIKeyList(func(v APIKeyTable) bool { return v.KeyHash == keyHash }), nil
+}
+
+func (store *memoryStore) SelectAPIKeyListFromID(ctx context.Context, id int64) ([]APIKeyTable, error) {
+	return store.selectAPIKeyList(func(v APIKeyTable) bool { return v.ID == id }), nil
+}
+
+func (store *memoryStore) SelectAPIKeyListFromUser(ctx context.Context, userName string) ([]APIKeyTable, error) {
+	return store.selectAPIKeyList(func(v APIKeyTable) bool { return v.UserName == userName }), nil
+}
+
+func (store *memoryStore) SelectAPIKeyListAll(ctx context.Context) ([]APIKeyTable, error) {
+	return store.selectAPIKeyList(func(v APIKeyTable) bool { return true }), nil
+}
+
+//selectAPIKeyList は条件に一致するAPIキーをコピーして返す
+func (store *memoryStore) selectAPIKeyList(match func(APIKeyTable) bool) []APIKeyTable {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	resultList := make([]APIKeyTable, 0)
+	for _, v := range store.apiKeys {
+		if match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	return resultList
+}
+
+func (store *memoryStore) DeleteAPIKey(ctx context.Context, id int64) error {
+	return store.deleteAPIKey(func(v APIKeyTable) bool { return v.ID == id })
+}
+
+func (store *memoryStore) DeleteAPIKeyOfUser(ctx context.Context, userName string) error {
+	return store.deleteAPIKey(func(v APIKeyTable) bool { return v.UserName == userName })
+}
+
+//deleteAPIKey は条件に一致するAPIキーを削除する
+func (store *memoryStore) deleteAPIKey(match func(APIKeyTable) bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	resultList := store.apiKeys[:0]
+	for _, v := range store.apiKeys {
+		if !match(v) {
+			resultList = append(resultList, v)
+		}
+	}
+	store.apiKeys = resultList
+	return nil
+}
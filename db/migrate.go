@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//テーブル名
+const schemaVersionTableName = "schema_version"
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+//SchemaVersionTable は適用済みマイグレーション情報テーブル
+type SchemaVersionTable struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+//Migration はマイグレーション1件分の情報を保持する
+type Migration struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	query     string
+}
+
+//Migrator はマイグレーションに対応したデータ保存先が実装する
+type Migrator interface {
+	Migrate(ctx context.Context) ([]Migration, error)
+	SelectMigrationList(ctx context.Context) ([]Migration, error)
+}
+
+//Migrate は未適用のマイグレーションをすべて適用し、適用したマイグレーションを返す
+func Migrate(ctx context.Context) ([]Migration, error) {
+	fmt.Printf("Migrate\n")
+	migrator, ok := dbStore.(Migrator)
+	if !ok {
+		return nil, nil //マイグレーション不要なデータ保存先
+	}
+
+	appliedList, err := migrator.Migrate(ctx)
+	if err != nil {
+		fmt.Printf("Migrate err=%s\n", err)
+		return appliedList, err
+	}
+	return appliedList, nil
+}
+
+//SelectMigrationList は適用済み・未適用のマイグレーション一覧を返す
+func SelectMigrationList(ctx context.Context) ([]Migration, error) {
+	fmt.Printf("SelectMigrationList\n")
+	migrator, ok := dbStore.(Migrator)
+	if !ok {
+		return nil, nil
+	}
+
+	migrationList, err := migrator.SelectMigrationList(ctx)
+	if err != nil {
+		fmt.Printf("SelectMigrationList err=%s\n", err)
+		return nil, err
+	}
+	return migrationList, nil
+}
+
+func (store *sqlStore) Migrate(ctx context.Context) ([]Migration, error) {
+	migrationList, err := store.SelectMigrationList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedList := make([]Migration, 0)
+	for _, migration := range migrationList {
+		if migration.Applied {
+			continue
+		}
+
+		fmt.Printf("Migrate apply version=%d name=%s\n", migration.Version, migration.Name)
+		if err := store.applyMigration(ctx, migration); err != nil {
+			return appliedList, fmt.Errorf("マイグレーション失敗 version=%d name=%s err=%s", migration.Version, migration.Name, err)
+		}
+		migration.Applied = true
+		migration.AppliedAt = time.Now()
+		appliedList = append(appliedList, migration)
+	}
+	return appliedList, nil
+}
+
+func (store *sqlStore) SelectMigrationList(ctx context.Context) ([]Migration, error) {
+	if err := store.createSchemaVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	migrationList, err := loadMigrationList(store.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	session := store.conn.NewSession(nil)
+	var versionList []SchemaVersionTable
+	_, err = session.Select("*").From(schemaVersionTableName).LoadContext(ctx, &versionList)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versionList {
+		for i := range migrationList {
+			if migrationList[i].Version == version.Version {
+				migrationList[i].Applied = true
+				migrationList[i].AppliedAt = version.AppliedAt
+			}
+		}
+	}
+	return migrationList, nil
+}
+
+//applyMigration はマイグレーションを実行して適用済みとして記録する
+//MySQLのDDLは暗黙的にコミットされるため、途中で失敗したときは手動で戻してから再実行すること
+func (store *sqlStore) applyMigration(ctx context.Context, migration Migration) error {
+	session := store.conn.NewSession(nil)
+	tx, err := session.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	for _, query := range splitQuery(migration.query, store.driver) {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	record := SchemaVersionTable{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+	_, err = tx.InsertInto(schemaVersionTableName).
+		Columns("version", "name", "applied_at").
+		Record(record).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//createSchemaVersionTable はマイグレーション管理テーブルが存在しないときは作成する
+func (store *sqlStore) createSchemaVersionTable(ctx context.Context) error {
+	_, err := store.conn.ExecContext(ctx, "create table if not exists "+schemaVersionTableName+
+		" (version int not null, name varchar(256) not null, applied_at datetime not null, primary key (version))")
+	return err
+}
+
+//loadMigrationList はバイナリに埋め込んだマイグレーションをバージョン順に読み込む
+//ファイル名は「0001_名前.sql」形式とする
+func loadMigrationList(driver string) ([]Migration, error) {
+	dirPath := path.Join("migrations", driver)
+	entryList, err := migrationFiles.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationList := make([]Migration, 0)
+	for _, entry := range entryList {
+		fileName := entry.Name()
+		if entry.IsDir() || path.Ext(fileName) != ".sql" {
+			continue
+		}
+		baseName := strings.TrimSuffix(fileName, ".sql")
+		pos := strings.Index(baseName, "_")
+		if pos < 0 {
+			return nil, fmt.Errorf("マイグレーションのファイル名が不正 name=%s", fileName)
+		}
+		version, err := strconv.Atoi(baseName[:pos])
+		if err != nil {
+			return nil, fmt.Errorf("マイグレーションのファイル名が不正 name=%s", fileName)
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dirPath, fileName))
+		if err != nil {
+			return nil, err
+		}
+		migrationList = append(migrationList, Migration{Version: version, Name: baseName[pos+1:], query: string(data)})
+	}
+
+	sort.Slice(migrationList, func(i, j int) bool {
+		return migrationList[i].Version < migrationList[j].Version
+	})
+	return migrationList, nil
+}
+
+//splitQuery は複数のSQL文を「;」で1文ずつに分割する（コメントだけの文は返さない）
+//引用符で囲まれた文字列・識別子とコメント内の「;」、CREATE TRIGGERのBEGIN〜END内の「;」では分割しない
+//MySQLの時は文字列内の「\」をエスケープ文字として扱う
+func splitQuery(query string, driver string) []string {
+	queryList := make([]string, 0)
+	start := 0
+	hasCode := false              //コメント・空白以外を含む
+	wordList := make([]string, 0) //文の先頭の単語（CREATE TRIGGERの判定に使用する）
+	isTrigger := false
+	depth := 0 //CREATE TRIGGER内のBEGIN・CASEの深さ
+	skipWord := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, driver == DriverMySQL)
+			hasCode = true
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 3
+			}
+			i += end + 3
+		case c == ';' && depth == 0:
+			if hasCode {
+				queryList = append(queryList, query[start:i])
+			}
+			start = i + 1
+			hasCode = false
+			wordList = wordList[:0]
+			isTrigger = false
+			skipWord = false
+		case isQueryWordChar(c):
+			end := i
+			for end < len(query) && isQueryWordChar(query[end]) {
+				end++
+			}
+			word := strings.ToLower(query[i:end])
+			i = end - 1
+			hasCode = true
+			if len(wordList) < 4 {
+				wordList = append(wordList, word)
+				if wordList[0] == "create" && word == "trigger" {
+					isTrigger = true
+				}
+			}
+			if !isTrigger {
+				continue
+			}
+			if skipWord {
+				skipWord = false
+				continue
+			}
+			switch word {
+			case "begin", "case":
+				depth++
+			case "end":
+				//END IF・END LOOPなどは深さを変えず、END CASEはCASEの終わりとする
+				switch nextQueryWord(query, end) {
+				case "if", "loop", "while", "repeat":
+					skipWord = true
+					continue
+				case "case":
+					skipWord = true
+				}
+				if depth > 0 {
+					depth--
+				}
+			}
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			hasCode = true
+		}
+	}
+	if hasCode {
+		queryList = append(queryList, query[start:])
+	}
+	return queryList
+}
+
+//skipQuoted は指定した位置の引用符に対応する閉じ引用符の位置を返す（引用符を2つ続けたものはエスケープとして扱う）
+func skipQuoted(query string, pos int, backslashEscape bool) int {
+	quote := query[pos]
+	for i := pos + 1; i < len(query); i++ {
+		if backslashEscape && quote != '`' && query[i] == '\\' {
+			i++
+			continue
+		}
+		if query[i] != quote {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == quote {
+			i++
+			continue
+		}
+		return i
+	}
+	return len(query) - 1
+}
+
+//nextQueryWord は指定した位置以降の空白を飛ばした次の単語を小文字で返す
+func nextQueryWord(query string, pos int) string {
+	for pos < len(query) && strings.IndexByte(" \t\r\n", query[pos]) >= 0 {
+		pos++
+	}
+	end := pos
+	for end < len(query) && isQueryWordChar(query[end]) {
+		end++
+	}
+	return strings.ToLower(query[pos:end])
+}
+
+//isQueryWordChar は単語（キーワード・識別子）に使用する文字かどうかを返す
+func isQueryWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
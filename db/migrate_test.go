@@ -0,0 +1,57 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitQuery(t *testing.T) {
+	testList := []struct {
+		driver   string
+		query    string
+		textList []string //分割後の各文に含まれる文字列
+	}{
+		{DriverSQLite, "create table a (id int);\n/* コメント; */\ncreate table b (id int);\n", []string{"table a", "table b"}},
+		{DriverSQLite, "insert into a values ('x;y', \"c;d\", `e;f`);\n-- 行コメント;\n", []string{"'x;y'"}},
+		{DriverSQLite, "insert into a values ('it''s;');\ninsert into a values ('\\');\n", []string{"'it''s;'", "'\\'"}},
+		{DriverMySQL, "insert into a values ('\\';');\ninsert into a values (1);", []string{"'\\';'", "(1)"}},
+		{DriverSQLite, "create trigger t after insert on a begin\n  update b set v = case when new.id > 0 then 1 else 0 end;\n  delete from c;\nend;\ncreate index i on a (id);", []string{"delete from c;\nend", "index i"}},
+		{DriverMySQL, "create trigger t before update on a for each row begin\n  if new.v < 0 then set new.v = 0; end if;\n  set new.w = 1;\nend;\nselect 1;", []string{"set new.w = 1;\nend", "select 1"}},
+		{DriverSQLite, "/* コメントだけ */\n", []string{}},
+	}
+	for i, test := range testList {
+		queryList := splitQuery(test.query, test.driver)
+		if len(queryList) != len(test.textList) {
+			t.Errorf("%d: queryList=%q", i, queryList)
+			continue
+		}
+		for j, text := range test.textList {
+			if !strings.Contains(queryList[j], text) {
+				t.Errorf("%d: query[%d]=%q", i, j, queryList[j])
+			}
+		}
+	}
+}
+
+func TestLoadMigrationList(t *testing.T) {
+	//すべてのマイグレーションがDB種別ごとに同じバージョンで存在し、空の文を含まない
+	mysqlList, err := loadMigrationList(DriverMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqliteList, err := loadMigrationList(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mysqlList) != len(sqliteList) {
+		t.Fatalf("mysql=%d sqlite=%d", len(mysqlList), len(sqliteList))
+	}
+	for i := range mysqlList {
+		if mysqlList[i].Version != i+1 || sqliteList[i].Version != i+1 || mysqlList[i].Name != sqliteList[i].Name {
+			t.Errorf("%d: mysql=%d %s sqlite=%d %s", i, mysqlList[i].Version, mysqlList[i].Name, sqliteList[i].Version, sqliteList[i].Name)
+		}
+		if len(splitQuery(mysqlList[i].query, DriverMySQL)) == 0 || len(splitQuery(sqliteList[i].query, DriverSQLite)) == 0 {
+			t.Errorf("%s: no query", mysqlList[i].Name)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+/* 一意制約を追加する前に重複している行を削除する（履歴は最後に登録された行、それ以外は最初に登録された行を残す） */
+delete b1 from books b1 inner join books b2 on b1.hash = b2.hash and b1.id > b2.id;
+delete f1 from folders f1 inner join folders f2 on f1.hash = f2.hash and f1.id > f2.id;
+delete u1 from users u1 inner join users u2 on u1.name = u2.name and u1.id > u2.id;
+delete h1 from histoires h1 inner join histoires h2 on h1.user_name = h2.user_name and h1.book_hash = h2.book_hash and h1.id < h2.id;
+
+/* utf8/utf8mb4でインデックスのキー長の上限（767バイト）を超えないよう、インデックスを作成するユーザー名は191文字までとする */
+alter table users modify name varchar(191) not null;
+alter table histoires modify user_name varchar(191) not null;
+
+create unique index books_hash_uindex on books (hash);
+create index books_folder_hash_index on books (folder_hash);
+create unique index folders_hash_uindex on folders (hash);
+create index folders_parent_hash_index on folders (parent_hash);
+create unique index users_name_uindex on users (name);
+create unique index histoires_user_name_book_hash_uindex on histoires (user_name, book_hash);
@@ -0,0 +1,3 @@
+/* 重複検出に使用する表紙画像の知覚ハッシュ */
+alter table books add column cover_hash varchar(16) not null default '';
+create index books_cover_hash_index on books (cover_hash);
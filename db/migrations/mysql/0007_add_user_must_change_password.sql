@@ -0,0 +1,2 @@
+/* 次回ログイン時にパスワードの変更が必要かどうか（初期管理者・管理者によるリセット時に設定する） */
+alter table users add column must_change_password int not null default 0;
@@ -0,0 +1,13 @@
+/* フォルダの閲覧許可（ユーザー名または権限レベルに対して許可し、指定したフォルダ以下を閲覧できる） */
+create table if not exists folder_grants
+(
+    id int not null unique auto_increment,
+    folder_hash varchar(64) not null,
+    user_name varchar(191) not null,
+    permission int not null,
+    created_at datetime not null,
+    primary key (id)
+) engine=innodb;
+
+create index folder_grants_user_name_index on folder_grants (user_name);
+create index folder_grants_permission_index on folder_grants (permission);
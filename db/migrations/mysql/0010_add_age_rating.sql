@@ -0,0 +1,17 @@
+/* アーカイブの対象年齢（ComicInfo.xmlのAgeRatingから取得する、-1は未取得、0は制限なし） */
+alter table books add column age_rating int not null default -1;
+
+/* ユーザーが閲覧できる最大の対象年齢（0は制限なし） */
+alter table users add column max_age_rating int not null default 0;
+
+/* フォルダの対象年齢（指定したフォルダ以下のアーカイブすべてに適用する） */
+create table if not exists folder_ratings
+(
+    id int not null unique auto_increment,
+    folder_hash varchar(64) not null,
+    age_rating int not null,
+    updated_at datetime not null,
+    primary key (id)
+) engine=innodb;
+
+create unique index folder_ratings_folder_hash_uindex on folder_ratings (folder_hash);
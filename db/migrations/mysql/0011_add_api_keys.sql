@@ -0,0 +1,16 @@
+/* ユーザーが発行したAPIキー（キーそのものは保存せずハッシュを保存する、有効期限なしの時は1970-01-01） */
+create table if not exists api_keys
+(
+    id int not null unique auto_increment,
+    user_name varchar(191) not null,
+    name varchar(256) not null,
+    key_hash varchar(64) not null,
+    key_prefix varchar(16) not null,
+    scope varchar(16) not null,
+    expires_at datetime not null,
+    created_at datetime not null,
+    primary key (id)
+) engine=innodb;
+
+create unique index api_keys_key_hash_uindex on api_keys (key_hash);
+create index api_keys_user_name_index on api_keys (user_name);
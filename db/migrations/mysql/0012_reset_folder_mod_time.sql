@@ -0,0 +1,2 @@
+/* 識別値・確認結果・知覚ハッシュ・対象年齢の追加前に登録したフォルダは変更なしとして読み込まれないため、次回の探索ですべて読み込み直す */
+update folders set mod_time = '1970-01-01 00:00:00';
@@ -0,0 +1,43 @@
+/* ログインユーザー情報 */
+create table if not exists users
+(
+    id integer primary key autoincrement,
+    name varchar(256) not null,
+    passhash varchar(256) not null,
+    permission int not null,
+    created_at datetime not null,
+    updated_at datetime not null
+);
+
+/* フォルダ情報 */
+create table if not exists folders
+(
+    id integer primary key autoincrement,
+    hash varchar(64) not null,
+    parent_hash varchar(64) not null,
+    file_path varchar(1024) not null,
+    mod_time datetime not null
+);
+
+/* アーカイブファイル情報 */
+create table if not exists books
+(
+    id integer primary key autoincrement,
+    hash varchar(64) not null,
+    folder_hash varchar(64) not null,
+    file_path varchar(1024) not null,
+    file_size int not null,
+    page int not null,
+    mod_time datetime not null
+);
+
+/* アーカイブの表示情報 */
+create table if not exists histoires
+(
+    id integer primary key autoincrement,
+    user_name varchar(256) not null,
+    book_hash varchar(64) not null,
+    read_pos int not null,
+    reaction int not null,
+    mod_time datetime not null
+);
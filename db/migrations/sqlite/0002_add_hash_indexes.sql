@@ -0,0 +1,12 @@
+/* 一意制約を追加する前に重複している行を削除する（履歴は最後に登録された行、それ以外は最初に登録された行を残す） */
+delete from books where id not in (select min(id) from books group by hash);
+delete from folders where id not in (select min(id) from folders group by hash);
+delete from users where id not in (select min(id) from users group by name);
+delete from histoires where id not in (select max(id) from histoires group by user_name, book_hash);
+
+create unique index if not exists books_hash_uindex on books (hash);
+create index if not exists books_folder_hash_index on books (folder_hash);
+create unique index if not exists folders_hash_uindex on folders (hash);
+create index if not exists folders_parent_hash_index on folders (parent_hash);
+create unique index if not exists users_name_uindex on users (name);
+create unique index if not exists histoires_user_name_book_hash_uindex on histoires (user_name, book_hash);
@@ -0,0 +1,4 @@
+/* 移動・名前変更を検出するためのファイル内容の識別値 */
+alter table books add column fingerprint varchar(80) not null default '';
+create index if not exists books_fingerprint_index on books (fingerprint);
+create index if not exists books_file_path_index on books (file_path);
@@ -0,0 +1,4 @@
+/* アーカイブの確認結果（0=未確認、1=正常、2=破損）とエラー内容 */
+alter table books add column status int not null default 0;
+alter table books add column error_message varchar(1024) not null default '';
+create index if not exists books_status_index on books (status);
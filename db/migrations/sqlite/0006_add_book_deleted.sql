@@ -0,0 +1,4 @@
+/* ファイルが見つからなくなったアーカイブの削除済みフラグと削除日時（猶予期間後に完全に削除する） */
+alter table books add column deleted int not null default 0;
+alter table books add column deleted_at datetime not null default '1970-01-01 00:00:00';
+create index if not exists books_deleted_index on books (deleted);
@@ -0,0 +1,15 @@
+/* ユーザーごとのトークンのバージョン（パスワード変更・全セッションのログアウト時に更新して発行済みのトークンを無効にする） */
+alter table users add column token_version int not null default 0;
+
+/* リフレッシュトークン（トークンそのものは保存せずハッシュを保存する） */
+create table if not exists refresh_tokens
+(
+    id integer primary key autoincrement,
+    user_name varchar(256) not null,
+    token_hash varchar(64) not null,
+    expires_at datetime not null,
+    created_at datetime not null
+);
+
+create unique index if not exists refresh_tokens_token_hash_uindex on refresh_tokens (token_hash);
+create index if not exists refresh_tokens_user_name_index on refresh_tokens (user_name);
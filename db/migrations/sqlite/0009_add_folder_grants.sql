@@ -0,0 +1,12 @@
+/* フォルダの閲覧許可（ユーザー名または権限レベルに対して許可し、指定したフォルダ以下を閲覧できる） */
+create table if not exists folder_grants
+(
+    id integer primary key autoincrement,
+    folder_hash varchar(64) not null,
+    user_name varchar(256) not null,
+    permission int not null,
+    created_at datetime not null
+);
+
+create index if not exists folder_grants_user_name_index on folder_grants (user_name);
+create index if not exists folder_grants_permission_index on folder_grants (permission);
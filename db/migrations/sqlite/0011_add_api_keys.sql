@@ -0,0 +1,15 @@
+/* ユーザーが発行したAPIキー（キーそのものは保存せずハッシュを保存する、有効期限なしの時は1970-01-01） */
+create table if not exists api_keys
+(
+    id integer primary key autoincrement,
+    user_name varchar(256) not null,
+    name varchar(256) not null,
+    key_hash varchar(64) not null,
+    key_prefix varchar(16) not null,
+    scope varchar(16) not null,
+    expires_at datetime not null,
+    created_at datetime not null
+);
+
+create unique index if not exists api_keys_key_hash_uindex on api_keys (key_hash);
+create index if not exists api_keys_user_name_index on api_keys (user_name);
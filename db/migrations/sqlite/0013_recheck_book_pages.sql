@@ -0,0 +1,3 @@
+/* 画像以外のファイルもページとして数えていたため、次回の探索ですべてのアーカイブを確認し直してページ数を数え直す */
+update books set status = 0;
+update folders set mod_time = '1970-01-01 00:00:00';
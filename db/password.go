@@ -0,0 +1,135 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+const (
+	argon2SaltLength = 16 //ユーザーごとに生成するソルトの長さ
+	argon2KeyLength  = 32 //生成するハッシュの長さ
+)
+
+//argon2Param はargon2idのハッシュ生成パラメーターを保持する
+type argon2Param struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+//CreatePasswordHash はパスワードから設定ファイルの方式でパスワードハッシュを生成する
+//ハッシュは方式を表す接頭辞付きの文字列で、ユーザーごとのソルトとコストを含む
+//（argon2id: $argon2id$v=19$m=メモリ量,t=反復回数,p=並列数$ソルト$ハッシュ、bcrypt: $2a$コスト$...）
+func CreatePasswordHash(password string) (string, error) {
+	loginConfig := config.GetConfig().Login
+	if loginConfig.PasswordAlgorithm == config.PasswordAlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), loginConfig.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	param := newArgon2ParamFromConfig()
+	return createArgon2Hash(password, salt, param), nil
+}
+
+//VerifyPassword はパスワードがパスワードハッシュと一致するかどうかを返す
+//一致した時、ハッシュが旧形式または設定ファイルと異なる方式・コストの時は、再生成が必要かどうかをtrueで返す
+func VerifyPassword(passHash string, password string) (bool, bool) {
+	loginConfig := config.GetConfig().Login
+	switch {
+	case strings.HasPrefix(passHash, "$argon2id$"):
+		param, salt, key, err := parseArgon2Hash(passHash)
+		if err != nil {
+			fmt.Printf("VerifyPassword err=%s\n", err)
+			return false, false
+		}
+		inputKey := argon2.IDKey([]byte(password), salt, param.time, param.memory, param.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, inputKey) != 1 {
+			return false, false
+		}
+		needRehash := loginConfig.PasswordAlgorithm == config.PasswordAlgorithmBcrypt || param != newArgon2ParamFromConfig()
+		return true, needRehash
+
+	case strings.HasPrefix(passHash, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(passHash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, _ := bcrypt.Cost([]byte(passHash))
+		needRehash := loginConfig.PasswordAlgorithm != config.PasswordAlgorithmBcrypt || cost != loginConfig.BcryptCost
+		return true, needRehash
+
+	default:
+		//旧形式（接頭辞なしのSHA-256）は一致した時に必ず再生成する
+		legacyHash := createLegacyPasswordHash(password)
+		if subtle.ConstantTimeCompare([]byte(passHash), []byte(legacyHash)) != 1 {
+			return false, false
+		}
+		return true, true
+	}
+}
+
+//newArgon2ParamFromConfig は設定ファイルからargon2idのハッシュ生成パラメーターを生成する
+func newArgon2ParamFromConfig() argon2Param {
+	loginConfig := config.GetConfig().Login
+	return argon2Param{
+		time:    uint32(loginConfig.Argon2Time),
+		memory:  uint32(loginConfig.Argon2MemoryKB),
+		threads: uint8(loginConfig.Argon2Threads),
+	}
+}
+
+//createArgon2Hash はargon2idのパスワードハッシュを生成する
+func createArgon2Hash(password string, salt []byte, param argon2Param) string {
+	key := argon2.IDKey([]byte(password), salt, param.time, param.memory, param.threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, param.memory, param.time, param.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+//parseArgon2Hash はargon2idのパスワードハッシュからパラメーター・ソルト・ハッシュを取得する
+func parseArgon2Hash(passHash string) (argon2Param, []byte, []byte, error) {
+	var param argon2Param
+	partList := strings.Split(passHash, "$")
+	if len(partList) != 6 {
+		return param, nil, nil, fmt.Errorf("argon2idのハッシュ形式が不正")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(partList[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return param, nil, nil, fmt.Errorf("argon2idのバージョンが不正 version=%s", partList[2])
+	}
+	if _, err := fmt.Sscanf(partList[3], "m=%d,t=%d,p=%d", &param.memory, &param.time, &param.threads); err != nil {
+		return param, nil, nil, fmt.Errorf("argon2idのパラメーターが不正 err=%s", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(partList[4])
+	if err != nil {
+		return param, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(partList[5])
+	if err != nil {
+		return param, nil, nil, err
+	}
+	return param, salt, key, nil
+}
+
+//createLegacyPasswordHash は旧形式（共通のソルトとパスワードのSHA-256）のパスワードハッシュを生成する
+func createLegacyPasswordHash(password string) string {
+	hashBytes := sha256.Sum256([]byte(config.GetConfig().Login.PassSalt + password))
+	hash := hex.EncodeToString(hashBytes[:])
+	return hash
+}
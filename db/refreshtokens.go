@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+//テーブル名
+const refreshTokenTableName = "refresh_tokens"
+
+//refreshTokenLength はリフレッシュトークンの長さ（バイト数）
+const refreshTokenLength = 32
+
+//RefreshTokenTable リフレッシュトークン情報テーブル
+type RefreshTokenTable struct {
+	ID        int64     `db:"id"`
+	UserName  string    `db:"user_name"`
+	TokenHash string    `db:"token_hash"` //トークンのSHA-256（トークンそのものは保存しない）
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+//CreateRefreshToken は新しいリフレッシュトークンを生成して登録し、トークンを返す
+func CreateRefreshToken(ctx context.Context, userName string, expiresAt time.Time) (string, error) {
+	if userName == "" {
+		return "", fmt.Errorf("パラメーターエラー")
+	}
+
+	tokenBytes := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	record := RefreshTokenTable{UserName: userName, TokenHash: createRefreshTokenHash(token), ExpiresAt: expiresAt, CreatedAt: time.Now()}
+	err := dbStore.InsertRefreshToken(ctx, record)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+//SelectRefreshToken はリフレッシュトークンの登録情報を返す（見つからない時はIDが0）
+func SelectRefreshToken(ctx context.Context, token string) (RefreshTokenTable, error) {
+	var result RefreshTokenTable
+	recordList, err := dbStore.SelectRefreshTokenList(ctx, createRefreshTokenHash(token))
+	if err != nil {
+		return result, err
+	}
+
+	if len(recordList) == 0 {
+		return result, nil
+	}
+	return recordList[0], nil
+}
+
+//DeleteRefreshToken はリフレッシュトークンを削除し、削除した件数を返す
+//同じトークンで同時に再発行された時に1つだけ成功させるため、件数が1の時だけ新しいトークンを発行する
+func DeleteRefreshToken(ctx context.Context, token string) (int64, error) {
+	if token == "" {
+		return 0, fmt.Errorf("パラメーターエラー")
+	}
+
+	count, err := dbStore.DeleteRefreshToken(ctx, createRefreshTokenHash(token))
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//DeleteRefreshTokenOfUser はユーザーのリフレッシュトークンをすべて削除する
+func DeleteRefreshTokenOfUser(ctx context.Context, userName string) error {
+	if userName == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.DeleteRefreshTokenOfUser(ctx, userName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//DeleteRefreshTokenExpired は有効期限が切れたリフレッシュトークンを削除する
+func DeleteRefreshTokenExpired(ctx context.Context) error {
+	err := dbStore.DeleteRefreshTokenExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//createRefreshTokenHash はリフレッシュトークンから保存用のハッシュを生成する
+func createRefreshTokenHash(token string) string {
+	hashBytes := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hashBytes[:])
+}
+
+func (store *sqlStore) InsertRefreshToken(ctx context.Context, record RefreshTokenTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(refreshTokenTableName).
+		Columns("user_name", "token_hash", "expires_at", "created_at").
+		Record(record).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqlStore) SelectRefreshTokenList(ctx context.Context, tokenHash string) ([]RefreshTokenTable, error) {
+	session := store.conn.NewSession(nil)
+	var resultList []RefreshTokenTable
+	_, err := session.Select("*").From(refreshTokenTableName).Where("token_hash = ?", tokenHash).LoadContext(ctx, &resultList)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultList, nil
+}
+
+func (store *sqlStore) DeleteRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	session := store.conn.NewSession(nil)
+	result, err := session.DeleteFrom(refreshTokenTableName).
+		Where("token_hash = ?", tokenHash).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (store *sqlStore) DeleteRefreshTokenOfUser(ctx context.Context, userName string) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(refreshTokenTableName).
+		Where("user_name = ?", userName).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStore) DeleteRefreshTokenExpired(ctx context.Context, now time.Time) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(refreshTokenTableName).
+		Where("expires_at < ?", now).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	dbStore Store //起動時に生成して全体で共有するデータ保存先
+)
+
+//Store はデータ保存先（MySQL, SQLiteなど）に対する操作を定義する
+type Store interface {
+	InsertBook(ctx context.Context, record BookTable) error
+	InsertBookList(ctx context.Context, recordList []BookTable) error
+	UpdateBookList(ctx context.Context, recordList []BookTable) error //1つのトランザクションで更新する
+	SoftDeleteBookList(ctx context.Context, idList []int64, deletedAt time.Time) error
+	DeleteBookList(ctx context.Context, idList []int64) error //アーカイブの履歴も削除する
+	SelectBookList(ctx context.Context, hash string) ([]BookTable, error)
+	SelectBookListFromPath(ctx context.Context, filePath string) ([]BookTable, error)
+	SelectBookListFromFingerprint(ctx context.Context, fingerprint string) ([]BookTable, error)
+	SelectBookListFromFolder(ctx context.Context, folderHash string) ([]BookTable, error)
+	SelectBookListFromStatus(ctx context.Context, status int) ([]BookTable, error)
+	SelectBookListFromDeleted(ctx context.Context, before time.Time) ([]BookTable, error)
+	SelectBookListAll(ctx context.Context) ([]BookTable, error)
+
+	InsertFolder(ctx context.Context, record FolderTable) error
+	UpdateFolder(ctx context.Context, record FolderTable) error
+	UpdateFolderParent(ctx context.Context, record FolderTable) error
+	ApplyFolderScanResult(ctx context.Context, result FolderScanResult) error //1つのトランザクションで反映する
+	DeleteFolderList(ctx context.Context, idList []int64) error               //フォルダ内のアーカイブは削除済みにする
+	SelectFolderList(ctx context.Context, hash string) ([]FolderTable, error)
+	SelectFolderListFromParent(ctx context.Context, parentHash string) ([]FolderTable, error)
+	SelectFolderListAll(ctx context.Context) ([]FolderTable, error)
+
+	InsertHistory(ctx context.Context, record HistoryTable) error
+	UpdateHistory(ctx context.Context, record HistoryTable) error
+	UpsertHistory(ctx context.Context, record HistoryTable) error //ReadPos, Reactionが-1の項目は既存の値のままとする
+	SelectHistoryList(ctx context.Context, userName string, bookHash string) ([]HistoryTable, error)
+	SelectHistoryListAll(ctx context.Context) ([]HistoryTable, error)
+	DeleteHistory(ctx context.Context, userName string) error
+	DeleteHistoryList(ctx context.Context, idList []int64) error
+
+	InsertUser(ctx context.Context, record UserTable) error
+	UpdateUser(ctx context.Context, record UserTable) error
+	UpdateUserPassword(ctx context.Context, record UserTable) error
+	UpdateUserTokenVersion(ctx context.Context, name string) error
+	UpdateUserMaxAgeRating(ctx context.Context, record UserTable) error
+	SelectUserList(ctx context.Context, name string) ([]UserTable, error)
+	SelectUserListAll(ctx context.Context) ([]UserTable, error)
+	DeleteUser(ctx context.Context, id int64) error
+
+	InsertRefreshToken(ctx context.Context, record RefreshTokenTable) error
+	SelectRefreshTokenList(ctx context.Context, tokenHash string) ([]RefreshTokenTable, error)
+	DeleteRefreshToken(ctx context.Context, tokenHash string) (int64, error) //削除した件数を返す
+	DeleteRefreshTokenOfUser(ctx context.Context, userName string) error
+	DeleteRefreshTokenExpired(ctx context.Context, now time.Time) error
+
+	InsertFolderGrant(ctx context.Context, record FolderGrantTable) error
+	SelectFolderGrantListFromUser(ctx context.Context, userName string, permission int) ([]FolderGrantTable, error)
+	SelectFolderGrantListAll(ctx context.Context) ([]FolderGrantTable, error)
+	DeleteFolderGrant(ctx context.Context, id int64) error
+	DeleteFolderGrantOfUser(ctx context.Context, userName string) error
+
+	ReplaceFolderRating(ctx context.Context, record FolderRatingTable) error //対象年齢が0の時は削除だけ行う
+	SelectFolderRatingListAll(ctx context.Context) ([]FolderRatingTable, error)
+
+	InsertAPIKey(ctx context.Context, record APIKeyTable) error
+	SelectAPIKeyList(ctx context.Context, keyHash string) ([]APIKeyTable, error)
+	SelectAPIKeyListFromID(ctx context.Context, id int64) ([]APIKeyTable, error)
+	SelectAPIKeyListFromUser(ctx context.Context, userName string) ([]APIKeyTable, error)
+	SelectAPIKeyListAll(ctx context.Context) ([]APIKeyTable, error)
+	DeleteAPIKey(ctx context.Context, id int64) error
+	DeleteAPIKeyOfUser(ctx context.Context, userName string) error
+
+	Close() error
+}
+
+//maxBatchCount は1回の一括処理で扱う最大件数（SQLiteのパラメーター数上限に収まるようにする）
+const maxBatchCount = 100
+
+//execBatch は件数をmaxBatchCount件ずつに分割して処理する
+func execBatch(count int, exec func(start int, end int) error) error {
+	for start := 0; start < count; start += maxBatchCount {
+		end := start + maxBatchCount
+		if end > count {
+			end = count
+		}
+		if err := exec(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SetStore はDBパッケージ内で使用するデータ保存先を設定する
+func SetStore(store Store) {
+	dbStore = store
+}
+
+//GetStore はDBパッケージ内で使用しているデータ保存先を返す
+func GetStore() Store {
+	return dbStore
+}
@@ -1,13 +1,11 @@
 package db
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/gocraft/dbr"
-	"github.com/mryp/squidgirl-go/config"
 )
 
 //テーブル名
@@ -15,12 +13,15 @@ const userTableName = "users"
 
 //UserTable ユーザー情報テーブル
 type UserTable struct {
-	ID         int64     `db:"id"`
-	Name       string    `db:"name"`
-	PassHash   string    `db:"passhash"`
-	Permission int       `db:"permission"`
-	CreatedAt  time.Time `db:"created_at"`
-	UpdatedAt  time.Time `db:"updated_at"`
+	ID                 int64     `db:"id"`
+	Name               string    `db:"name"`
+	PassHash           string    `db:"passhash"`
+	Permission         int       `db:"permission"`
+	CreatedAt          time.Time `db:"created_at"`
+	UpdatedAt          time.Time `db:"updated_at"`
+	MustChangePassword bool      `db:"must_change_password"` //次回ログイン時にパスワードの変更が必要
+	TokenVersion       int       `db:"token_version"`        //発行済みのトークンを無効にする時に更新する
+	MaxAgeRating       int       `db:"max_age_rating"`       //閲覧できる最大の対象年齢（0は制限なし）
 }
 
 //定数
@@ -29,37 +30,93 @@ const (
 	UserPermissionAdmin = 100
 )
 
-func InsertUser(name string, password string, permission int) error {
+//InsertUser はユーザー情報を登録する（mustChangePasswordがtrueの時は次回ログイン時にパスワードの変更が必要とする）
+func InsertUser(ctx context.Context, name string, password string, permission int, mustChangePassword bool) error {
 	if name == "" || password == "" || permission == 0 {
 		return fmt.Errorf("パラメーターエラー")
 	}
 
-	passHash := CreatePasswordHash(password)
-	record := UserTable{Name: name, PassHash: passHash, Permission: permission, CreatedAt: time.Now(), UpdatedAt: time.Now()}
-	err := insertUser(nil, record)
+	passHash, err := CreatePasswordHash(password)
+	if err != nil {
+		return err
+	}
+	record := UserTable{Name: name, PassHash: passHash, Permission: permission, CreatedAt: time.Now(), UpdatedAt: time.Now(), MustChangePassword: mustChangePassword}
+	err = dbStore.InsertUser(ctx, record)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func UpdateUser(name string, password string, permission int) error {
+func UpdateUser(ctx context.Context, name string, password string, permission int) error {
 	if name == "" || password == "" || permission == 0 {
 		return fmt.Errorf("パラメーターエラー")
 	}
 
-	passHash := CreatePasswordHash(password)
+	passHash, err := CreatePasswordHash(password)
+	if err != nil {
+		return err
+	}
 	record := UserTable{Name: name, PassHash: passHash, Permission: permission, CreatedAt: time.Now(), UpdatedAt: time.Now()}
-	err := updateUser(nil, record)
+	err = dbStore.UpdateUser(ctx, record)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//UpdateUserPassword はユーザーのパスワードと、次回ログイン時にパスワードの変更が必要かどうかを更新する
+func UpdateUserPassword(ctx context.Context, name string, password string, mustChangePassword bool) error {
+	if name == "" || password == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	passHash, err := CreatePasswordHash(password)
+	if err != nil {
+		return err
+	}
+	record := UserTable{Name: name, PassHash: passHash, UpdatedAt: time.Now(), MustChangePassword: mustChangePassword}
+	err = dbStore.UpdateUserPassword(ctx, record)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//UpdateUserMaxAgeRating はユーザーが閲覧できる最大の対象年齢を更新する（0は制限なし）
+func UpdateUserMaxAgeRating(ctx context.Context, name string, maxAgeRating int) error {
+	if name == "" || maxAgeRating < 0 {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	record := UserTable{Name: name, MaxAgeRating: maxAgeRating, UpdatedAt: time.Now()}
+	err := dbStore.UpdateUserMaxAgeRating(ctx, record)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SelectUser(name string) (UserTable, error) {
+//RevokeUserToken はユーザーのトークンのバージョンを更新し、リフレッシュトークンを削除して発行済みのトークンをすべて無効にする
+func RevokeUserToken(ctx context.Context, name string) error {
+	if name == "" {
+		return fmt.Errorf("パラメーターエラー")
+	}
+
+	err := dbStore.UpdateUserTokenVersion(ctx, name)
+	if err != nil {
+		return err
+	}
+	err = dbStore.DeleteRefreshTokenOfUser(ctx, name)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func SelectUser(ctx context.Context, name string) (UserTable, error) {
 	var result UserTable
-	recordList, err := selectUserList(nil, name)
+	recordList, err := dbStore.SelectUserList(ctx, name)
 	if err != nil {
 		return result, err
 	}
@@ -70,72 +127,96 @@ func SelectUser(name string) (UserTable, error) {
 	return recordList[0], nil
 }
 
-func SelectUserAll() ([]UserTable, error) {
-	recordList, err := selectUserListAll(nil)
+func SelectUserAll(ctx context.Context) ([]UserTable, error) {
+	recordList, err := dbStore.SelectUserListAll(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return recordList, nil
 }
 
-func DeleteUser(id int64) error {
+func DeleteUser(ctx context.Context, id int64) error {
 	if id == 0 {
 		return fmt.Errorf("パラメーターエラー")
 	}
 
-	err := deleteUser(nil, id)
+	err := dbStore.DeleteUser(ctx, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func insertUser(session *dbr.Session, record UserTable) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) InsertUser(ctx context.Context, record UserTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.InsertInto(userTableName).
+		Columns("name", "passhash", "permission", "created_at", "updated_at", "must_change_password").
+		Record(record).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
 
-	_, err = session.InsertInto(userTableName).
-		Columns("name", "passhash", "permission", "created_at", "updated_at").
-		Record(record).
-		Exec()
+	return nil
+}
+
+func (store *sqlStore) UpdateUser(ctx context.Context, record UserTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(userTableName).
+		Set("passhash", record.PassHash).
+		Set("permission", record.Permission).
+		Set("updated_at", record.UpdatedAt).
+		Where("name = ?", record.Name).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-
 	return nil
 }
 
-func updateUser(session *dbr.Session, record UserTable) error {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) UpdateUserPassword(ctx context.Context, record UserTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(userTableName).
+		Set("passhash", record.PassHash).
+		Set("must_change_password", record.MustChangePassword).
+		Set("updated_at", record.UpdatedAt).
+		Where("name = ?", record.Name).
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	return nil
+}
 
-	_, err = session.Update(userTableName).
-		Set("passhash", record.PassHash).
-		Set("permission", record.Permission).
+func (store *sqlStore) UpdateUserMaxAgeRating(ctx context.Context, record UserTable) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(userTableName).
+		Set("max_age_rating", record.MaxAgeRating).
 		Set("updated_at", record.UpdatedAt).
 		Where("name = ?", record.Name).
-		Exec()
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func selectUserList(session *dbr.Session, name string) ([]UserTable, error) {
-	session, err := ConnectDBRecheck(session)
+func (store *sqlStore) UpdateUserTokenVersion(ctx context.Context, name string) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.Update(userTableName).
+		Set("token_version", dbr.Expr("token_version + 1")).
+		Where("name = ?", name).
+		ExecContext(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer session.Close()
+	return nil
+}
 
+func (store *sqlStore) SelectUserList(ctx context.Context, name string) ([]UserTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []UserTable
-	_, err = session.Select("*").From(userTableName).Where("name = ?", name).Load(&resultList)
+	_, err := session.Select("*").From(userTableName).Where("name = ?", name).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -143,15 +224,10 @@ func selectUserList(session *dbr.Session, name string) ([]UserTable, error) {
 	return resultList, nil
 }
 
-func selectUserListAll(session *dbr.Session) ([]UserTable, error) {
-	session, err := ConnectDBRecheck(session)
-	if err != nil {
-		return nil, err
-	}
-	defer session.Close()
-
+func (store *sqlStore) SelectUserListAll(ctx context.Context) ([]UserTable, error) {
+	session := store.conn.NewSession(nil)
 	var resultList []UserTable
-	_, err = session.Select("*").From(userTableName).Load(&resultList)
+	_, err := session.Select("*").From(userTableName).LoadContext(ctx, &resultList)
 	if err != nil {
 		return nil, err
 	}
@@ -159,25 +235,13 @@ func selectUserListAll(session *dbr.Session) ([]UserTable, error) {
 	return resultList, nil
 }
 
-func deleteUser(session *dbr.Session, id int64) error {
-	session, err := ConnectDBRecheck(session)
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	_, err = session.DeleteFrom(userTableName).
+func (store *sqlStore) DeleteUser(ctx context.Context, id int64) error {
+	session := store.conn.NewSession(nil)
+	_, err := session.DeleteFrom(userTableName).
 		Where("id = ?", id).
-		Exec()
+		ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
-
-//CreatePasswordHash パスワードからパスワードハッシュを生成する
-func CreatePasswordHash(password string) string {
-	hashBytes := sha256.Sum256([]byte(config.GetConfig().Login.PassSalt + password))
-	hash := hex.EncodeToString(hashBytes[:])
-	return hash
-}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+const (
+	duplicateReasonFingerprint = "fingerprint" //ファイル内容が同じ
+	duplicateReasonCover       = "cover"       //表紙画像が似ている
+
+	flatCoverHash = "0000000000000000" //白紙など明暗のない表紙は似ていると判定しない
+)
+
+//duplicateGroup は重複している可能性があるアーカイブのグループ
+type duplicateGroup struct {
+	Reason   string
+	BookList []db.BookTable
+}
+
+//findDuplicateGroups はファイル内容の識別値が同じ、または表紙画像の知覚ハッシュの距離がmaxDistance以下のアーカイブをグループにして返す
+func findDuplicateGroups(bookList []db.BookTable, maxDistance int) []duplicateGroup {
+	targetList := make([]db.BookTable, 0, len(bookList))
+	for _, book := range bookList {
+		if book.Status != db.BookStatusBroken && !book.Deleted {
+			targetList = append(targetList, book)
+		}
+	}
+
+	//同じグループのアーカイブを同じ親にまとめる（Union-Find）
+	parentList := make([]int, len(targetList))
+	for i := range parentList {
+		parentList[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parentList[i] != i {
+			parentList[i] = find(parentList[i])
+		}
+		return parentList[i]
+	}
+	union := func(i int, j int) {
+		parentList[find(i)] = find(j)
+	}
+
+	fingerprintMap := make(map[string]int)
+	for i, book := range targetList {
+		if book.Fingerprint == "" {
+			continue
+		}
+		if j, ok := fingerprintMap[book.Fingerprint]; ok {
+			union(i, j)
+		} else {
+			fingerprintMap[book.Fingerprint] = i
+		}
+	}
+	unionSimilarCover(targetList, maxDistance, find, union)
+
+	//2件以上のグループだけを返す
+	groupMap := make(map[int][]db.BookTable)
+	for i, book := range targetList {
+		root := find(i)
+		groupMap[root] = append(groupMap[root], book)
+	}
+	groupList := make([]duplicateGroup, 0)
+	for _, list := range groupMap {
+		if len(list) < 2 {
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].FilePath < list[j].FilePath
+		})
+		reason := duplicateReasonFingerprint
+		for _, book := range list {
+			if book.Fingerprint == "" || book.Fingerprint != list[0].Fingerprint {
+				reason = duplicateReasonCover
+				break
+			}
+		}
+		groupList = append(groupList, duplicateGroup{Reason: reason, BookList: list})
+	}
+	sort.Slice(groupList, func(i, j int) bool {
+		return groupList[i].BookList[0].FilePath < groupList[j].BookList[0].FilePath
+	})
+	return groupList
+}
+
+//coverBandKey は知覚ハッシュを区間に分けた時の区間位置と値
+type coverBandKey struct {
+	band  int
+	value uint64
+}
+
+//unionSimilarCover は表紙画像の知覚ハッシュの距離がmaxDistance以下のアーカイブを同じグループにまとめる
+//すべての組み合わせを比較しないよう、同じハッシュのアーカイブを先にまとめてから、ハッシュをmaxDistance+1個の区間に分け、
+//いずれかの区間の値が一致するハッシュ同士だけを比較する（距離がmaxDistance以下なら必ずいずれかの区間が一致する）
+func unionSimilarCover(targetList []db.BookTable, maxDistance int, find func(i int) int, union func(i int, j int)) {
+	if maxDistance < 0 {
+		return
+	}
+
+	hashMap := make(map[uint64]int)
+	for i, book := range targetList {
+		if book.CoverHash == "" || book.CoverHash == flatCoverHash {
+			continue
+		}
+		value, err := strconv.ParseUint(book.CoverHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		if j, ok := hashMap[value]; ok {
+			union(i, j)
+		} else {
+			hashMap[value] = i
+		}
+	}
+
+	bandCount := maxDistance + 1
+	if bandCount > 64 {
+		bandCount = 1 //すべてのハッシュが距離64以下なので1つの区間（すべて一致）として比較する
+	}
+	bucketMap := make(map[coverBandKey][]int)
+	for value, i := range hashMap {
+		for band := 0; band < bandCount; band++ {
+			key := coverBandKey{band: band}
+			if maxDistance < 64 {
+				start := uint(band * 64 / bandCount)
+				end := uint((band + 1) * 64 / bandCount)
+				key.value = (value >> start) & (1<<(end-start) - 1)
+			}
+			bucketMap[key] = append(bucketMap[key], i)
+		}
+	}
+	for _, indexList := range bucketMap {
+		for x := range indexList {
+			for y := x + 1; y < len(indexList); y++ {
+				i, j := indexList[x], indexList[y]
+				if find(i) == find(j) {
+					continue
+				}
+				distance := coverHashDistance(targetList[i].CoverHash, targetList[j].CoverHash)
+				if distance >= 0 && distance <= maxDistance {
+					union(i, j)
+				}
+			}
+		}
+	}
+}
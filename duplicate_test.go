@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//countDuplicatePair はグループ内のアーカイブの組み合わせの数を返す
+func countDuplicatePair(groupList []duplicateGroup) int {
+	count := 0
+	for _, group := range groupList {
+		count += len(group.BookList) * (len(group.BookList) - 1) / 2
+	}
+	return count
+}
+
+func TestFindDuplicateGroups(t *testing.T) {
+	bookList := []db.BookTable{
+		{FilePath: "a.zip", Fingerprint: "f1", CoverHash: "ffff0000ffff0000", Status: db.BookStatusOK},
+		{FilePath: "b.zip", Fingerprint: "f1", CoverHash: "0123456789abcdef", Status: db.BookStatusOK},
+		{FilePath: "c.zip", Fingerprint: "f2", CoverHash: "ffff0000ffff0003", Status: db.BookStatusOK},
+		{FilePath: "d.zip", Fingerprint: "f3", CoverHash: "00000000ffffffff", Status: db.BookStatusOK},
+		{FilePath: "e.zip", Fingerprint: "f4", CoverHash: flatCoverHash, Status: db.BookStatusOK},
+		{FilePath: "f.zip", Fingerprint: "f5", CoverHash: flatCoverHash, Status: db.BookStatusOK},
+		{FilePath: "g.zip", Fingerprint: "f3", CoverHash: "00000000ffffffff", Status: db.BookStatusBroken},
+	}
+	groupList := findDuplicateGroups(bookList, 4)
+	if len(groupList) != 1 || len(groupList[0].BookList) != 3 || groupList[0].Reason != duplicateReasonCover {
+		t.Fatalf("groups=%v", groupList)
+	}
+	for i, name := range []string{"a.zip", "b.zip", "c.zip"} {
+		if groupList[0].BookList[i].FilePath != name {
+			t.Errorf("%d: %s", i, groupList[0].BookList[i].FilePath)
+		}
+	}
+}
+
+func TestFindDuplicateGroupsCoverDistance(t *testing.T) {
+	//区間に分けて比較した結果がすべての組み合わせを比較した結果と同じになる
+	random := rand.New(rand.NewSource(1))
+	valueList := make([]uint64, 0)
+	for i := 0; i < 40; i++ {
+		value := random.Uint64()
+		valueList = append(valueList, value)
+		for j := 0; j < 3; j++ {
+			//近いハッシュを追加する
+			noise := uint64(0)
+			for k := 0; k < random.Intn(8); k++ {
+				noise |= 1 << uint(random.Intn(64))
+			}
+			valueList = append(valueList, value^noise)
+		}
+	}
+	bookList := make([]db.BookTable, 0, len(valueList))
+	for i, value := range valueList {
+		bookList = append(bookList, db.BookTable{FilePath: fmt.Sprintf("%03d.zip", i), CoverHash: fmt.Sprintf("%016x", value), Status: db.BookStatusOK})
+	}
+
+	for _, maxDistance := range []int{0, 3, 6, 10, 63, 64} {
+		//すべての組み合わせを比較してグループを作る
+		parentList := make([]int, len(valueList))
+		for i := range parentList {
+			parentList[i] = i
+		}
+		var find func(i int) int
+		find = func(i int) int {
+			if parentList[i] != i {
+				parentList[i] = find(parentList[i])
+			}
+			return parentList[i]
+		}
+		for i := range valueList {
+			for j := i + 1; j < len(valueList); j++ {
+				if bits.OnesCount64(valueList[i]^valueList[j]) <= maxDistance {
+					parentList[find(i)] = find(j)
+				}
+			}
+		}
+		sizeMap := make(map[int]int)
+		for i := range valueList {
+			sizeMap[find(i)]++
+		}
+		expected := 0
+		for _, size := range sizeMap {
+			expected += size * (size - 1) / 2
+		}
+
+		if count := countDuplicatePair(findDuplicateGroups(bookList, maxDistance)); count != expected {
+			t.Errorf("maxDistance=%d pair=%d expected=%d", maxDistance, count, expected)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -17,30 +18,36 @@ var (
 
 //FileListRequest はファイル一覧情報取得のリクエストデータを保持する
 type FileListRequest struct {
-	Hash   string `json:"hash" xml:"hash" form:"hash" query:"hash"`
-	Offset int    `json:"offset" xml:"offset" form:"offset" query:"offset"`
-	Limit  int    `json:"limit" xml:"limit" form:"limit" query:"limit"`
+	Library    string `json:"library" xml:"library" form:"library" query:"library"`
+	Hash       string `json:"hash" xml:"hash" form:"hash" query:"hash"`
+	Offset     int    `json:"offset" xml:"offset" form:"offset" query:"offset"`
+	Limit      int    `json:"limit" xml:"limit" form:"limit" query:"limit"`
+	HideBroken bool   `json:"hidebroken" xml:"hidebroken" form:"hidebroken" query:"hidebroken"`
 }
 
 //FileListResponce はファイル一覧情報取得のレスポンスデータを保持する
 type FileListResponce struct {
-	Name     string                  `json:"name" xml:"name"`
-	AllCount int                     `json:"allcount" xml:"allcount"`
-	Count    int                     `json:"count" xml:"count"`
-	Files    []FileListFilesResponce `json:"files" xml:"files"`
+	Name      string                  `json:"name" xml:"name"`
+	Library   string                  `json:"library" xml:"library"`
+	Direction string                  `json:"direction" xml:"direction"`
+	AllCount  int                     `json:"allcount" xml:"allcount"`
+	Count     int                     `json:"count" xml:"count"`
+	Files     []FileListFilesResponce `json:"files" xml:"files"`
 }
 
 //FileListFilesResponce はファイル一覧取得レスポンスのファイル情報をを保持する
 type FileListFilesResponce struct {
-	Hash     string    `json:"hash" xml:"hash"`
-	Name     string    `json:"name" xml:"name"`
-	Size     int       `json:"size" xml:"size"`
-	Page     int       `json:"page" xml:"page"`
-	IsDir    bool      `json:"isdir" xml:"isdir"`
-	ModTime  time.Time `json:"modtime" xml:"modtime"`
-	ReadTime time.Time `json:"readtime" xml:"readtime"`
-	Index    int       `json:"index" xml:"index"`
-	Reaction int       `json:"reaction" xml:"reaction"`
+	Hash      string    `json:"hash" xml:"hash"`
+	Name      string    `json:"name" xml:"name"`
+	Size      int       `json:"size" xml:"size"`
+	Page      int       `json:"page" xml:"page"`
+	IsDir     bool      `json:"isdir" xml:"isdir"`
+	ModTime   time.Time `json:"modtime" xml:"modtime"`
+	ReadTime  time.Time `json:"readtime" xml:"readtime"`
+	Index     int       `json:"index" xml:"index"`
+	Reaction  int       `json:"reaction" xml:"reaction"`
+	Broken    bool      `json:"broken" xml:"broken"`
+	AgeRating int       `json:"agerating" xml:"agerating"`
 }
 
 //FileListHandler はファイル一覧を取得しレスポンとして返す
@@ -54,42 +61,51 @@ func FileListHandler(c echo.Context) error {
 	//トークンからユーザー名を取得
 	loginUser := NewLoginUserFromRequest(c)
 
-	//ルートを取得
-	rootFolder, err := db.SelectFolderRoot()
+	//ライブラリのルートと指定したフォルダを取得（フォルダ指定なしの時はルート）
+	library, ok := findLibraryFromName(req.Library)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "指定したライブラリが見つからない")
+	}
+	ctx := c.Request().Context()
+	rootFolder, selectFolder, err := selectLibraryFolder(ctx, library, req.Hash)
 	if err != nil {
 		return err
 	}
+	folderHash := selectFolder.Hash
 
-	folderHash := req.Hash
-	if folderHash == "" {
-		//フォルダハッシュをルートとして取得
-		folderHash = rootFolder.Hash
-	}
-
-	//指定したフォルダの親フォルダを取得する
-	selectFolder, err := db.SelectFolderFromHash(folderHash)
+	//閲覧が許可されていないフォルダは開けない
+	access, err := newFolderAccess(ctx, loginUser)
 	if err != nil {
 		return err
 	}
+	if !access.CanBrowse(selectFolder.FilePath) {
+		return echo.NewHTTPError(http.StatusForbidden, "閲覧が許可されていない")
+	}
+
+	//指定したフォルダの親フォルダを取得する（ライブラリのルートより上は返さない）
 	var parentFolder db.FolderTable
-	if selectFolder.ParentHash != "" {
-		parentFolder, err = db.SelectFolderFromHash(selectFolder.ParentHash)
+	if selectFolder.Hash != rootFolder.Hash && selectFolder.ParentHash != "" {
+		parentFolder, err = db.SelectFolderFromHash(ctx, selectFolder.ParentHash)
 		if err != nil {
 			return err
 		}
 	}
 
 	//フォルダ一覧を取得
-	folderList, err := db.SelectFolderListFromParent(folderHash)
+	folderList, err := db.SelectFolderListFromParent(ctx, folderHash)
 	if err != nil {
 		return err
 	}
 
-	//ファイル一覧を取得
-	bookList, err := db.SelectBookListFromFolder(folderHash)
+	//ファイル一覧を取得（許可されたフォルダまでの上位フォルダではアーカイブは返さない）
+	bookList, err := db.SelectBookListFromFolder(ctx, folderHash)
 	if err != nil {
 		return err
 	}
+	bookList = db.ExcludeDeletedBook(bookList)
+	if !access.CanRead(selectFolder.FilePath) {
+		bookList = nil
+	}
 
 	//ファイル情報レスポンスを作成
 	files := make([]FileListFilesResponce, 0)
@@ -99,14 +115,23 @@ func FileListHandler(c echo.Context) error {
 	}
 	index := 0
 	for _, v := range folderList {
+		if !access.CanBrowse(v.FilePath) {
+			continue
+		}
 		if index >= req.Offset && index < req.Offset+req.Limit {
 			files = append(files, createFileListResponceFromFolder(v))
 		}
 		index++
 	}
 	for _, v := range bookList {
+		if req.HideBroken && v.Status == db.BookStatusBroken {
+			continue
+		}
+		if !access.CanReadBook(v) {
+			continue
+		}
 		if index >= req.Offset && index < req.Offset+req.Limit {
-			files = append(files, createFileListResponceFromBook(v, loginUser.UserName))
+			files = append(files, createFileListResponceFromBook(ctx, v, loginUser.UserName))
 		}
 		index++
 	}
@@ -114,10 +139,12 @@ func FileListHandler(c echo.Context) error {
 	//取得フォルダ情報レスポンスを作成
 	responce := new(FileListResponce)
 	if rootFolder.Hash == selectFolder.Hash {
-		responce.Name = "ルートフォルダ"
+		responce.Name = library.Name
 	} else {
 		responce.Name = filepath.Base(selectFolder.FilePath)
 	}
+	responce.Library = library.Name
+	responce.Direction = library.Direction
 	responce.AllCount = index
 	responce.Count = len(files)
 	responce.Files = files
@@ -156,9 +183,13 @@ func createFileListResponceFromFolder(folder db.FolderTable) FileListFilesRespon
 }
 
 //createFileListResponceFromBook は指定したアーカイブのファイル情報を生成して返す
-func createFileListResponceFromBook(book db.BookTable, userName string) FileListFilesResponce {
+func createFileListResponceFromBook(ctx context.Context, book db.BookTable, userName string) FileListFilesResponce {
 	name := filepath.Base(book.FilePath)
-	history, err := db.SelectHistory(userName, book.Hash)
+	ageRating := book.AgeRating
+	if ageRating == db.AgeRatingUnread {
+		ageRating = 0
+	}
+	history, err := db.SelectHistory(ctx, userName, book.Hash)
 	readTime := unknownTime
 	index := 0
 	reaction := 0
@@ -167,20 +198,26 @@ func createFileListResponceFromBook(book db.BookTable, userName string) FileList
 		readTime = history.ModTime
 		index = history.ReadPos
 		reaction = history.Reaction
+		if book.Page > 0 && index >= book.Page {
+			//ページ数を数え直して少なくなった時は最後のページから読む
+			index = book.Page - 1
+		}
 	} else {
 		//見つからない（まだ未読）
 		fmt.Printf("createFileListResponceFromBook SelectHistory NG=%v", err)
 	}
 
 	return FileListFilesResponce{
-		Hash:     book.Hash,
-		Name:     name,
-		Size:     book.FileSize,
-		Page:     book.Page,
-		IsDir:    false,
-		ModTime:  book.ModTime.UTC(),
-		ReadTime: readTime,
-		Index:    index,
-		Reaction: reaction,
+		Hash:      book.Hash,
+		Name:      name,
+		Size:      book.FileSize,
+		Page:      book.Page,
+		IsDir:     false,
+		ModTime:   book.ModTime.UTC(),
+		ReadTime:  readTime,
+		Index:     index,
+		Reaction:  reaction,
+		Broken:    book.Status == db.BookStatusBroken,
+		AgeRating: ageRating,
 	}
 }
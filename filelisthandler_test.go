@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//requestFileList はファイル一覧を取得してステータスコードとレスポンスを返す
+func requestFileList(t *testing.T, userName string, hash string) (int, *FileListResponce) {
+	form := url.Values{}
+	form.Set("library", testLibraryName)
+	form.Set("hash", hash)
+	form.Set("offset", "0")
+	form.Set("limit", "100")
+	c, rec := newTestContext(http.MethodPost, "/api/filelist", form, newTestLoginUser(t, userName))
+	code := responseCode(FileListHandler(c), rec)
+	if code != http.StatusOK {
+		return code, nil
+	}
+
+	res := new(FileListResponce)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	return code, res
+}
+
+//fileNameList はファイル一覧のうち親フォルダ以外の名前一覧を返す
+func fileNameList(res *FileListResponce, upperHash string) []string {
+	nameList := make([]string, 0)
+	for _, file := range res.Files {
+		if file.Hash == upperHash {
+			continue
+		}
+		nameList = append(nameList, file.Name)
+	}
+	return nameList
+}
+
+func TestFileListHandler(t *testing.T) {
+	code, res := requestFileList(t, testUser, "")
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	if res.Library != testLibraryName || res.AllCount != 2 {
+		t.Errorf("library=%s allcount=%d files=%v", res.Library, res.AllCount, res.Files)
+	}
+
+	code, res = requestFileList(t, testUser, testHash(t, testOpenDir))
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	nameList := fileNameList(res, testHash(t, testLibraryDir))
+	if len(nameList) != 2 || nameList[0] != "book1.zip" || nameList[1] != "book2.zip" {
+		t.Errorf("files=%v", nameList)
+	}
+	for _, file := range res.Files {
+		if file.Hash == testHash(t, testTeenBook) && file.AgeRating != 13 {
+			t.Errorf("agerating=%d", file.AgeRating)
+		}
+	}
+}
+
+func TestFileListHandlerGrant(t *testing.T) {
+	//許可されていないフォルダはルートの一覧に含めない
+	code, res := requestFileList(t, testGuestUser, "")
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	nameList := fileNameList(res, "")
+	if len(nameList) != 1 || nameList[0] != "open" {
+		t.Errorf("files=%v", nameList)
+	}
+
+	//許可されていないフォルダは開けない
+	code, _ = requestFileList(t, testGuestUser, testHash(t, testPrivateDir))
+	if code != http.StatusForbidden {
+		t.Errorf("private code=%d", code)
+	}
+	code, _ = requestFileList(t, testGuestUser, testHash(t, testOpenDir))
+	if code != http.StatusOK {
+		t.Errorf("open code=%d", code)
+	}
+}
+
+func TestFileListHandlerAgeRating(t *testing.T) {
+	//対象年齢が最大の対象年齢より大きいアーカイブは一覧に含めない
+	code, res := requestFileList(t, testKidUser, testHash(t, testOpenDir))
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	nameList := fileNameList(res, testHash(t, testLibraryDir))
+	if len(nameList) != 1 || nameList[0] != "book1.zip" {
+		t.Errorf("files=%v", nameList)
+	}
+}
+
+func TestFileListHandlerReadPos(t *testing.T) {
+	//ページ数より後ろの読み込み位置は最後のページとして返す
+	if err := db.InsertHistory(context.Background(), testAdminUser, testHash(t, testOpenBook), 5, -1, true); err != nil {
+		t.Fatal(err)
+	}
+	code, res := requestFileList(t, testAdminUser, testHash(t, testOpenDir))
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	for _, file := range res.Files {
+		if file.Hash == testHash(t, testOpenBook) && file.Index != 2 {
+			t.Errorf("index=%d", file.Index)
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
-
 	"time"
 
 	"github.com/mryp/squidgirl-go/config"
@@ -19,10 +20,16 @@ var (
 	fileWatcherTargetExt = []string{".zip"}
 )
 
+const (
+	minDeleteLimitCount = 10 //削除数がこれ以下の時は削除する割合の上限を確認しない（小さなライブラリで削除できなくなるのを防ぐ）
+)
+
 //FileWatcher はファイル監視処理情報を保持する構造体
 type FileWatcher struct {
 	mutex         *sync.Mutex
 	maxCacheCount int
+	queueMutex    *sync.Mutex
+	scanQueue     []string //探索中のため開始を待っている探索対象のフォルダ
 }
 
 //NewFileWatcher はファイル監視処理にデフォルト値をセットして返す
@@ -34,40 +41,241 @@ func NewFileWatcher() *FileWatcher {
 
 	watcher := new(FileWatcher)
 	watcher.mutex = new(sync.Mutex)
+	watcher.queueMutex = new(sync.Mutex)
 	watcher.maxCacheCount = config.GetConfig().File.CacheMaxCount
 	return watcher
 }
 
 //StartBackgroundTask はファイル監視によるタスク処理をバックグランドでまとめて実行する
 func (watcher *FileWatcher) StartBackgroundTask() {
+	if err := watcher.StartScan("", false, false); err != nil {
+		fmt.Printf("StartBackgroundTask err=%s\n", err)
+	}
+}
+
+//StartScan はライブラリ探索をバックグラウンドで開始する（すでに探索中の時はエラーを返す）
+//basePathを指定した時はそのフォルダ以下だけを探索し、fullがtrueの時は更新日時が変わっていないフォルダも確認する
+//forceがtrueの時は見つからなくなったアーカイブが多くても削除する
+func (watcher *FileWatcher) StartScan(basePath string, full bool, force bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !scanProgress.Start(basePath, full, cancel) {
+		cancel()
+		return fmt.Errorf("前回の探索が終わっていない")
+	}
 	go func() {
-		watcher.ClearFile()
+		defer watcher.startQueuedScan() //探索の終了後に待っている探索を開始する
+		defer scanProgress.Finish()
+		defer cancel()
+
+		//移動・名前変更されたアーカイブを引き継げるよう、削除より先に登録を行う
+		existPathMap := watcher.RegistFile(ctx, basePath, full)
+		if ctx.Err() != nil {
+			fmt.Printf("StartScan 中止 path=%s\n", basePath)
+			return
+		}
+		watcher.ClearFile(ctx, basePath, existPathMap, force)
+		watcher.PurgeFile(ctx)
 		watcher.ClearCache()
-		watcher.RegistFile()
 	}()
+	return nil
+}
+
+//QueueScan は指定したフォルダ以下の探索を開始し、すでに探索中の時は終わるまで待ってから開始する
+//同じフォルダの探索がすでに待っている時は追加しない
+func (watcher *FileWatcher) QueueScan(basePath string) {
+	watcher.queueMutex.Lock()
+	for _, path := range watcher.scanQueue {
+		if path == basePath {
+			watcher.queueMutex.Unlock()
+			return
+		}
+	}
+	watcher.scanQueue = append(watcher.scanQueue, basePath)
+	watcher.queueMutex.Unlock()
+
+	watcher.startQueuedScan()
+}
+
+//startQueuedScan は待っている探索を1件開始する（探索中の時は何もしない）
+func (watcher *FileWatcher) startQueuedScan() {
+	watcher.queueMutex.Lock()
+	defer watcher.queueMutex.Unlock()
+
+	if len(watcher.scanQueue) == 0 {
+		return
+	}
+	basePath := watcher.scanQueue[0]
+	if err := watcher.StartScan(basePath, false, false); err != nil {
+		//探索中のため待つ（終了時に再度呼び出される）
+		return
+	}
+	watcher.scanQueue = watcher.scanQueue[1:]
+}
+
+//CancelScan は実行中のライブラリ探索を中止する（探索中でない時はfalseを返す）
+func (watcher *FileWatcher) CancelScan() bool {
+	return scanProgress.Cancel()
 }
 
 //RegistFile はファイル・フォルダを探索し新規・更新項目を追加する
-func (watcher *FileWatcher) RegistFile() {
+//basePathが空の時はすべてのライブラリを探索する
+//探索で存在を確認したパスを返す（途中でエラーが発生した時はnilを返す）
+func (watcher *FileWatcher) RegistFile(ctx context.Context, basePath string, full bool) map[string]bool {
 	//ロックをかける
 	watcher.mutex.Lock()
 	defer watcher.mutex.Unlock()
 
+	scan, err := newLibraryScan(ctx, full, scanProgress)
+	if err != nil {
+		return nil
+	}
+
 	//ファイル探索開始
-	baseDir := config.GetConfig().File.WatchDir
-	err := filepath.Walk(baseDir, registFileWalk)
+	if basePath == "" {
+		for _, library := range config.GetLibraryList() {
+			scan.scanDir(library.WatchDir, "", newScanFilterFromPath(library, library.WatchDir))
+		}
+	} else {
+		library, ok := findLibrary(basePath)
+		if !ok {
+			return nil
+		}
+		filter := newScanFilterFromPath(library, basePath)
+		if !filter.isTarget(basePath, true) {
+			return nil
+		}
+
+		//親フォルダが未登録の時は先に登録する
+		parentPath := filepath.Dir(basePath)
+		parentHash := ""
+		if filepath.Clean(basePath) != filepath.Clean(library.WatchDir) {
+			watcher.registParentDir(ctx, library.WatchDir, parentPath)
+			parent, _ := db.SelectFolder(ctx, parentPath)
+			parentHash = parent.Hash
+		}
+		scan.scanDir(basePath, parentHash, filter)
+	}
+	scan.wait()
+	if scan.failed {
+		return nil
+	}
+	return scan.existPathMap
+}
+
+//RegistPath は指定したファイル・フォルダ（フォルダの時は配下すべて）を登録・更新する
+func (watcher *FileWatcher) RegistPath(ctx context.Context, path string) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	library, ok := findLibrary(path)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	filter := newScanFilterFromPath(library, filepath.Dir(path))
+	if !filter.isTarget(path, info.IsDir()) {
+		return
+	}
+
+	//親フォルダが未登録の時は先に登録する
+	dirPath := filepath.Dir(path)
+	watcher.registParentDir(ctx, library.WatchDir, dirPath)
+	scan, err := newLibraryScan(ctx, true, NewScanProgress())
+	if err != nil {
+		return
+	}
+	parent := scan.folderMap[dirPath]
+	if info.IsDir() {
+		if filepath.Clean(path) == filepath.Clean(library.WatchDir) {
+			parent = db.FolderTable{} //ライブラリのルートは親フォルダなし
+		}
+		scan.scanDir(path, parent.Hash, filter.withIgnoreFile(path))
+	} else {
+		//フォルダ内の他のファイルは確認していないため、フォルダの更新日時は設定しない
+		scan.registBookList(dirPath, parent, []dirEntry{{name: filepath.Base(path)}}, time.Time{})
+	}
+	scan.wait()
+}
+
+//RemovePath は指定したファイル・フォルダ（フォルダの時は配下すべて）が存在しなくなっていたら削除する
+func (watcher *FileWatcher) RemovePath(ctx context.Context, path string) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	_, err := watcher.clearAll(ctx, func(targetPath string) bool {
+		if !isSubPath(path, targetPath) {
+			return false
+		}
+		_, err := os.Stat(targetPath)
+		return os.IsNotExist(err)
+	}, false)
+	if err != nil {
+		fmt.Printf("RemovePath err=%s path=%s\n", err, path)
+	}
+}
+
+//registParentDir は指定したフォルダからベースフォルダまでの未登録のフォルダを登録する
+func (watcher *FileWatcher) registParentDir(ctx context.Context, baseDir string, dirPath string) {
+	if !isSubPath(baseDir, dirPath) {
+		return
+	}
+	folder, _ := db.SelectFolder(ctx, dirPath)
+	if folder.Hash != "" {
+		return
+	}
+
+	//上の階層から順に登録する
+	if filepath.Clean(dirPath) != filepath.Clean(baseDir) {
+		watcher.registParentDir(ctx, baseDir, filepath.Dir(dirPath))
+	}
+	info, err := os.Stat(dirPath)
 	if err != nil {
 		return
 	}
+	registDirInfo(ctx, dirPath, info)
 }
 
 //ClearFile は登録されているファイル・フォルダが存在しなかった時は削除する
-func (watcher *FileWatcher) ClearFile() {
+//basePathを指定した時はそのフォルダ以下だけを対象とし、空の時はすべてを対象とする（どのライブラリにも含まれない項目も削除する）
+//existPathMapを指定した時は探索で見つからなかった項目を削除し、nilの時はすべての項目の存在を確認する
+//forceがtrueの時は削除する割合の上限を超えても削除する
+func (watcher *FileWatcher) ClearFile(ctx context.Context, basePath string, existPathMap map[string]bool, force bool) {
 	watcher.mutex.Lock()
 	defer watcher.mutex.Unlock()
 
-	watcher.clearFolderAll()
-	watcher.clearBookAll()
+	removedCount, err := watcher.clearAll(ctx, func(path string) bool {
+		return isClearTarget(basePath, existPathMap, path)
+	}, force)
+	if err != nil {
+		fmt.Printf("ClearFile err=%s\n", err)
+		scanProgress.setError(err)
+		return
+	}
+	scanProgress.addRemoved(removedCount)
+}
+
+//PurgeFile は削除済みにしてから猶予期間が過ぎたアーカイブ情報を、履歴・サムネイルと合わせて完全に削除する
+//ライブラリのルートフォルダが読み込めない時は、そのライブラリのアーカイブは削除しない
+func (watcher *FileWatcher) PurgeFile(ctx context.Context) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	graceDays := config.GetConfig().File.DeleteGraceDays
+	bookList, err := db.SelectBookListFromDeleted(ctx, time.Now().AddDate(0, 0, -graceDays))
+	if err != nil {
+		return
+	}
+	isAvailable := newLibraryAvailableChecker()
+	purgeList := make([]db.BookTable, 0)
+	for _, book := range bookList {
+		if isAvailable(book.FilePath) {
+			purgeList = append(purgeList, book)
+		}
+	}
+	watcher.deleteBookList(ctx, purgeList)
 }
 
 //ClearCache はキャッシュ上限を超えた時、使用頻度が低いキャッシュファイルを削除する
@@ -78,115 +286,133 @@ func (watcher *FileWatcher) ClearCache() {
 	watcher.clearOldCacheAll()
 }
 
-//registFileWalk はfilepath.Walkでファイルが見つかるたびに呼び出される
-func registFileWalk(path string, info os.FileInfo, err error) error {
-	if info.IsDir() {
-		registDirInfo(path, info)
-	} else {
-		registFileInfo(path, info)
-	}
-	return nil
-}
-
 //registDirInfo はフォルダ情報を登録する
-func registDirInfo(path string, info os.FileInfo) {
-	folder, _ := db.SelectFolder(path)
+func registDirInfo(ctx context.Context, path string, info os.FileInfo) {
+	folder, _ := db.SelectFolder(ctx, path)
 	if folder.Hash == "" {
 		parentDir := filepath.Dir(path)
-		parentFolder, err := db.SelectFolder(parentDir)
+		parentFolder, err := db.SelectFolder(ctx, parentDir)
 		if err != nil {
 			fmt.Printf("registDirInfo 親フォルダ未登録 err%s\n", err)
 			return
 		}
-		db.InsertFolder(path, parentFolder.Hash, info.ModTime())
+		db.InsertFolder(ctx, path, parentFolder.Hash, info.ModTime())
 	} else {
 		fmt.Printf("registDirInfo dir exists hash=%s\n", folder.Hash)
 	}
 }
 
-//registFileInfo はアーカイブ情報を登録する
-func registFileInfo(path string, info os.FileInfo) {
-	ext := filepath.Ext(path)
-	for _, v := range fileWatcherTargetExt {
-		if v == ext {
-			registFileZipInfo(path, info)
-			break
-		}
-	}
+//isSubPath は指定したパスがベースパスと同じかその配下にあるかどうかを返す
+func isSubPath(basePath string, path string) bool {
+	basePath = filepath.Clean(basePath)
+	path = filepath.Clean(path)
+	return path == basePath || strings.HasPrefix(path, basePath+string(filepath.Separator))
 }
 
-//registFileZipInfo はZIPファイル形式のアーカイブ情報を登録する
-func registFileZipInfo(path string, info os.FileInfo) {
-	dir := filepath.Dir(path)
-	folder, err := db.SelectFolder(dir)
+//clearAll は削除対象のフォルダ情報を削除してアーカイブ情報を削除済みにし、削除したアーカイブ数を返す
+//削除するフォルダ内のアーカイブ情報も合わせて削除済みにする（猶予期間が0日の時はすぐに履歴・サムネイルと合わせて削除する）
+//ライブラリのルートフォルダが読み込めない時はそのライブラリ内の項目を対象外とし、
+//forceがfalseで削除するアーカイブの割合が上限を超える時は何も削除せずにエラーを返す
+func (watcher *FileWatcher) clearAll(ctx context.Context, isTarget func(path string) bool, force bool) (int, error) {
+	folderList, err := db.SelectFolderAll(ctx)
 	if err != nil {
-		fmt.Printf("registFileZipInfo フォルダ未登録 err%s\n", err)
-		return
+		return 0, err
+	}
+	bookList, err := db.SelectBookAll(ctx)
+	if err != nil {
+		return 0, err
 	}
-	dirHash := folder.Hash
+	bookList = db.ExcludeDeletedBook(bookList)
 
-	thum := NewThumbnail()
-	bookPage := NewBookPage("", path)
-	book, _ := db.SelectBook(path)
-	if book.Hash == "" {
-		//新規登録
-		page, _ := bookPage.GetPageCount()
-		thum.CreateFile(path)
-		db.InsertBook(dirHash, path, int(info.Size()), page, info.ModTime())
-	} else if !isEquleDateTime(book.ModTime, info.ModTime()) {
-		//更新あり
-		page, _ := bookPage.GetPageCount()
-		thum.CreateFile(path)
-		db.UpdateBook(dirHash, path, int(info.Size()), page, info.ModTime())
-	} else {
-		if !thum.IsExist(thum.GetFilePathFromHash(book.Hash)) {
-			thum.CreateFile(path)
+	isAvailable := newLibraryAvailableChecker()
+	folderIDList := make([]int64, 0)
+	folderHashMap := make(map[string]bool)
+	for _, folder := range folderList {
+		if !isAvailable(folder.FilePath) || !isTarget(folder.FilePath) {
+			continue //フォルダあり
 		}
+		folderIDList = append(folderIDList, folder.ID)
+		folderHashMap[folder.Hash] = true
 	}
-}
-
-//isEquleDateTime は指定したフィル時刻が同一かどうか（分単位まででチェックする）
-func isEquleDateTime(t1 time.Time, t2 time.Time) bool {
-	t1Text := t1.UTC().Format("2006-01-02 15:04")
-	t2Text := t2.UTC().Format("2006-01-02 15:04")
-	if t1Text == t2Text {
-		return true
+	removeList := make([]db.BookTable, 0)
+	bookIDList := make([]int64, 0)
+	for _, book := range bookList {
+		if !isAvailable(book.FilePath) || (!folderHashMap[book.FolderHash] && !isTarget(book.FilePath)) {
+			continue //ファイルあり
+		}
+		removeList = append(removeList, book)
+		bookIDList = append(bookIDList, book.ID)
 	}
-	return false
-}
 
-//clearFolderAll はファイルが存在しないフォルダ情報をすべてクリアーする
-func (watcher *FileWatcher) clearFolderAll() {
-	folderList, err := db.SelectFolderAll()
-	if err != nil {
-		return
+	maxPercent := config.GetConfig().File.MaxDeletePercent
+	if !force && maxPercent > 0 && len(removeList) > minDeleteLimitCount && len(removeList)*100 > len(bookList)*maxPercent {
+		return 0, fmt.Errorf("見つからなくなったアーカイブが多すぎるため削除しない count=%d/%d", len(removeList), len(bookList))
 	}
 
-	for _, folder := range folderList {
-		_, err := os.Stat(folder.FilePath)
-		if !os.IsNotExist(err) {
-			continue //フォルダあり
+	//アーカイブを削除済みにしてからフォルダを削除する
+	if config.GetConfig().File.DeleteGraceDays <= 0 {
+		if err := watcher.deleteBookList(ctx, removeList); err != nil {
+			return 0, err
 		}
-
-		db.DeleteFolder(folder.ID)
+	} else {
+		if err := db.SoftDeleteBookList(ctx, bookIDList, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+	if err := db.DeleteFolderList(ctx, folderIDList); err != nil {
+		return len(removeList), err
 	}
+	return len(removeList), nil
 }
 
-//clearBookAll はファイルが存在しないアーカイブ情報をすべてクリアーする
-func (watcher *FileWatcher) clearBookAll() {
-	bookList, err := db.SelectBookAll()
-	if err != nil {
-		return
+//deleteBookList はアーカイブ情報を履歴・サムネイルと合わせて完全に削除する
+func (watcher *FileWatcher) deleteBookList(ctx context.Context, bookList []db.BookTable) error {
+	idList := make([]int64, 0, len(bookList))
+	for _, book := range bookList {
+		idList = append(idList, book.ID)
 	}
-
+	if err := db.DeleteBookList(ctx, idList); err != nil {
+		return err
+	}
+	thum := NewThumbnail()
 	for _, book := range bookList {
-		_, err := os.Stat(book.FilePath)
-		if !os.IsNotExist(err) {
-			continue //フォルダあり
+		thum.RemoveFile(book.Hash)
+	}
+	return nil
+}
+
+//newLibraryAvailableChecker は指定したパスが読み込めないライブラリ内にないかどうかを返す関数を生成する
+//どのライブラリにも含まれないパスは読み込めるものとする
+func newLibraryAvailableChecker() func(path string) bool {
+	unavailableList := make([]string, 0)
+	for _, library := range config.GetLibraryList() {
+		if !isLibraryAvailable(library) {
+			unavailableList = append(unavailableList, library.WatchDir)
+		}
+	}
+	return func(path string) bool {
+		for _, dirPath := range unavailableList {
+			if isSubPath(dirPath, path) {
+				return false
+			}
 		}
+		return true
+	}
+}
 
-		db.DeleteBook(book.ID)
+//isClearTarget は指定したパスの登録情報を削除するかどうかを返す
+func isClearTarget(basePath string, existPathMap map[string]bool, path string) bool {
+	if basePath != "" && !isSubPath(basePath, path) {
+		return false //対象外
+	}
+	if _, ok := findLibrary(path); !ok {
+		return true //ライブラリ設定から外された
+	}
+	if existPathMap != nil {
+		return !existPathMap[path]
 	}
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
 }
 
 //clearOldCacheAll は上限を超えた履歴が古いキャッシュファイルをすべて削除する
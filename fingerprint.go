@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	fingerprintBlockSize = 64 * 1024 //ファイル先頭・末尾から読み込むサイズ（ZIPの中央ディレクトリは末尾にある）
+)
+
+//CreateFileFingerprint はファイル内容からファイルを識別する値を生成する
+//ファイル全体を読まずに済むよう、ファイルサイズと先頭・末尾のデータから生成する
+func CreateFileFingerprint(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, file, fingerprintBlockSize); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size > fingerprintBlockSize {
+		offset := size - fingerprintBlockSize
+		if offset < fingerprintBlockSize {
+			offset = fingerprintBlockSize
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%d-%s", size, hex.EncodeToString(hash.Sum(nil))), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+
+	"github.com/labstack/echo"
+
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//folderAccess はログインユーザーが閲覧できるフォルダ・アーカイブを保持する
+//ユーザー名または権限レベルに対する閲覧許可が1件でもあるユーザーは、許可されたフォルダ以下だけを閲覧できる
+//閲覧許可が1件もないユーザーと管理者はすべてのフォルダを閲覧できる
+//最大の対象年齢が設定されたユーザーは、対象年齢がそれより大きいフォルダ・アーカイブを閲覧できない
+type folderAccess struct {
+	restricted      bool
+	pathList        []string //閲覧を許可されたフォルダのパス
+	maxAgeRating    int      //0は制限なし
+	folderRatingMap map[string]int
+}
+
+//newFolderAccess はログインユーザーの閲覧許可と対象年齢の制限を取得して返す
+func newFolderAccess(ctx context.Context, loginUser *LoginUser) (*folderAccess, error) {
+	access := new(folderAccess)
+	if loginUser.MaxAgeRating > 0 {
+		access.maxAgeRating = loginUser.MaxAgeRating
+		ratingList, err := db.SelectFolderRatingAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		access.folderRatingMap = make(map[string]int)
+		for _, rating := range ratingList {
+			access.folderRatingMap[rating.FolderHash] = rating.AgeRating
+		}
+	}
+	if loginUser.AuthLevel == db.UserPermissionAdmin {
+		return access, nil
+	}
+
+	grantList, err := db.SelectFolderGrantListFromUser(ctx, loginUser.UserName, loginUser.AuthLevel)
+	if err != nil {
+		return nil, err
+	}
+	if len(grantList) == 0 {
+		return access, nil
+	}
+
+	access.restricted = true
+	access.pathList = make([]string, 0)
+	for _, grant := range grantList {
+		//フォルダがなくなった許可は無視する（同じパスのフォルダが再登録されると同じハッシュになる）
+		folder, err := db.SelectFolderFromHash(ctx, grant.FolderHash)
+		if err != nil {
+			return nil, err
+		}
+		if folder.Hash != "" {
+			access.pathList = append(access.pathList, folder.FilePath)
+		}
+	}
+	return access, nil
+}
+
+//CanRead は指定したパスのフォルダ・アーカイブを閲覧できるかどうかを返す（許可されたフォルダ以下は閲覧できる）
+func (access *folderAccess) CanRead(path string) bool {
+	return access.isGranted(path) && access.isAllowedRating(access.folderRating(path))
+}
+
+//CanReadBook は指定したアーカイブを閲覧できるかどうかを返す（アーカイブとフォルダの対象年齢の大きい方で確認する）
+func (access *folderAccess) CanReadBook(book db.BookTable) bool {
+	return access.CanRead(book.FilePath) && access.isAllowedRating(book.AgeRating)
+}
+
+//CanBrowse は指定したパスのフォルダを開けるかどうかを返す
+//閲覧できるフォルダに加え、許可されたフォルダまでたどるために上位のフォルダも開ける（中のアーカイブは閲覧できない）
+func (access *folderAccess) CanBrowse(path string) bool {
+	if !access.isAllowedRating(access.folderRating(path)) {
+		return false
+	}
+	if access.isGranted(path) {
+		return true
+	}
+	for _, grantPath := range access.pathList {
+		if isSubPath(path, grantPath) {
+			return true
+		}
+	}
+	return false
+}
+
+//isGranted は指定したパスが閲覧を許可されたフォルダ以下かどうかを返す
+func (access *folderAccess) isGranted(path string) bool {
+	if !access.restricted {
+		return true
+	}
+	for _, grantPath := range access.pathList {
+		if isSubPath(grantPath, path) {
+			return true
+		}
+	}
+	return false
+}
+
+//isAllowedRating は指定した対象年齢を閲覧できるかどうかを返す
+func (access *folderAccess) isAllowedRating(ageRating int) bool {
+	return access.maxAgeRating == 0 || ageRating <= access.maxAgeRating
+}
+
+//folderRating は指定したパスと上位のフォルダに設定された対象年齢の最大値を返す
+func (access *folderAccess) folderRating(path string) int {
+	if len(access.folderRatingMap) == 0 {
+		return 0
+	}
+	rating := 0
+	for {
+		if folderRating, ok := access.folderRatingMap[db.CreateFolderHash(path)]; ok && folderRating > rating {
+			rating = folderRating
+		}
+		parentPath := filepath.Dir(path)
+		if parentPath == path {
+			break
+		}
+		path = parentPath
+	}
+	return rating
+}
+
+//checkBookAccess は指定したハッシュのアーカイブをログインユーザーが閲覧できるか確認し、閲覧できない時は403エラーを返す
+func checkBookAccess(ctx context.Context, loginUser *LoginUser, hash string) error {
+	access, err := newFolderAccess(ctx, loginUser)
+	if err != nil {
+		return err
+	}
+	if !access.restricted && access.maxAgeRating == 0 {
+		return nil
+	}
+
+	book, err := db.SelectBookFromHash(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if book.Hash == "" || !access.CanReadBook(book) {
+		return echo.NewHTTPError(http.StatusForbidden, "閲覧が許可されていない")
+	}
+	return nil
+}
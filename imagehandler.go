@@ -9,7 +9,6 @@ import (
 	"os"
 
 	"github.com/labstack/echo"
-	"github.com/mryp/squidgirl-go/config"
 	"github.com/mryp/squidgirl-go/db"
 )
 
@@ -37,9 +36,14 @@ func ThumbnailHandler(c echo.Context) error {
 	}
 	fmt.Printf("request=%v\n", *req)
 
+	err := checkBookAccess(c.Request().Context(), NewLoginUserFromRequest(c), hash)
+	if err != nil {
+		return err
+	}
+
 	thum := NewThumbnail()
 	thumImagePath := thum.GetFilePathFromHash(hash)
-	_, err := os.Stat(thumImagePath)
+	_, err = os.Stat(thumImagePath)
 	if os.IsNotExist(err) {
 		//画像なしを返却する
 		if req.Base64 {
@@ -70,7 +74,15 @@ func PageHandler(c echo.Context) error {
 	}
 	fmt.Printf("request=%v\n", *req)
 
-	bookPage := NewBookPage(hash, "")
+	//トークンからユーザー名を取得
+	ctx := c.Request().Context()
+	loginUser := NewLoginUserFromRequest(c)
+	err := checkBookAccess(ctx, loginUser, hash)
+	if err != nil {
+		return err
+	}
+
+	bookPage := NewBookPage(ctx, hash, "")
 	exist, filePath := bookPage.IsExistPageFile(req.Index, req.MaxHeight, req.MaxWidth)
 	if filePath == "" {
 		return c.NoContent(http.StatusBadRequest)
@@ -82,17 +94,14 @@ func PageHandler(c echo.Context) error {
 		}
 	}
 
-	//トークンからユーザー名を取得
-	loginUser := NewLoginUserFromRequest(c)
-
 	//現在の読み込み位置を保存
-	err := db.InsertHistory(loginUser.UserName, hash, req.Index, -1, true)
+	err = db.InsertHistory(ctx, loginUser.UserName, hash, req.Index, -1, true)
 	if err != nil {
 		return err
 	}
 
-	//次のページ以降の展開キャッシュを行う（非同期）
-	go bookPage.UnzipPageFileMutex(req.Index+1, config.GetConfig().File.PreCacheImageCount, req.MaxHeight, req.MaxWidth)
+	//読み込み方向と速度に合わせて前後ページの展開キャッシュを行う（非同期）
+	NewPreCacher().Request(ctx, loginUser.UserName, bookPage, req.Index, req.MaxHeight, req.MaxWidth)
 
 	//データを返却
 	if req.Base64 {
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+//requestPage はページ画像を取得してステータスコードを返す
+func requestPage(t *testing.T, userName string, bookPath string) int {
+	c, rec := newTestContext(http.MethodGet, "/api/page/?index=0", nil, newTestLoginUser(t, userName))
+	c.SetParamNames("hash")
+	c.SetParamValues(testHash(t, bookPath))
+	return responseCode(PageHandler(c), rec)
+}
+
+func TestPageHandler(t *testing.T) {
+	if code := requestPage(t, testUser, testOpenBook); code != http.StatusOK {
+		t.Errorf("code=%d", code)
+	}
+}
+
+func TestPageHandlerForbidden(t *testing.T) {
+	//許可されていないフォルダのアーカイブ
+	if code := requestPage(t, testGuestUser, testSecretBook); code != http.StatusForbidden {
+		t.Errorf("guest code=%d", code)
+	}
+	//最大の対象年齢より大きいアーカイブ
+	if code := requestPage(t, testKidUser, testTeenBook); code != http.StatusForbidden {
+		t.Errorf("kid code=%d", code)
+	}
+	if code := requestPage(t, testKidUser, testOpenBook); code != http.StatusOK {
+		t.Errorf("kid open code=%d", code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//findLibrary は指定したパスが含まれるライブラリ設定を返す
+func findLibrary(path string) (config.LibraryConfig, bool) {
+	for _, library := range config.GetLibraryList() {
+		if isSubPath(library.WatchDir, path) {
+			return library, true
+		}
+	}
+	return config.LibraryConfig{}, false
+}
+
+//findLibraryFromName は指定した名前のライブラリ設定を返す（名前が空の時は先頭のライブラリを返す）
+func findLibraryFromName(name string) (config.LibraryConfig, bool) {
+	libraryList := config.GetLibraryList()
+	if name == "" && len(libraryList) > 0 {
+		return libraryList[0], true
+	}
+	for _, library := range libraryList {
+		if library.Name == name {
+			return library, true
+		}
+	}
+	return config.LibraryConfig{}, false
+}
+
+//selectLibraryFolder はライブラリのルートフォルダと、指定したハッシュのフォルダを取得する
+//ハッシュが空の時はルートフォルダを返し、ライブラリ外のフォルダを指定した時はエラーを返す
+func selectLibraryFolder(ctx context.Context, library config.LibraryConfig, hash string) (db.FolderTable, db.FolderTable, error) {
+	rootFolder, err := db.SelectFolderRoot(ctx, library.WatchDir)
+	if err != nil {
+		return db.FolderTable{}, db.FolderTable{}, err
+	}
+	if hash == "" {
+		return rootFolder, rootFolder, nil
+	}
+
+	selectFolder, err := db.SelectFolderFromHash(ctx, hash)
+	if err != nil {
+		return db.FolderTable{}, db.FolderTable{}, err
+	}
+	if selectFolder.Hash == "" || !isSubPath(library.WatchDir, selectFolder.FilePath) {
+		return db.FolderTable{}, db.FolderTable{}, fmt.Errorf("指定したフォルダがライブラリ内にない")
+	}
+	return rootFolder, selectFolder, nil
+}
+
+//isLibraryAvailable はライブラリのルートフォルダが読み込めて空でないかどうかを返す
+//外付けディスクがマウントされていない時はルートフォルダがないか空になるため、登録情報を削除しないよう確認する
+func isLibraryAvailable(library config.LibraryConfig) bool {
+	entryList, err := os.ReadDir(library.WatchDir)
+	if err != nil {
+		fmt.Printf("isLibraryAvailable err=%s path=%s\n", err, library.WatchDir)
+		return false
+	}
+	if len(entryList) == 0 {
+		fmt.Printf("isLibraryAvailable empty path=%s\n", library.WatchDir)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//LibraryListResponce はライブラリ一覧取得レスポンスデータ構造体
+type LibraryListResponce struct {
+	Count     int                          `json:"count" xml:"count"`
+	Libraries []LibraryListLibraryResponce `json:"libraries" xml:"libraries"`
+}
+
+//LibraryListLibraryResponce はライブラリ一覧取得レスポンスのライブラリ情報を保持する
+type LibraryListLibraryResponce struct {
+	Name      string `json:"name" xml:"name"`
+	Hash      string `json:"hash" xml:"hash"`
+	Direction string `json:"direction" xml:"direction"`
+	Interval  int    `json:"interval" xml:"interval"`
+}
+
+//LibraryListHandler はライブラリ一覧を取得する
+func LibraryListHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	access, err := newFolderAccess(ctx, NewLoginUserFromRequest(c))
+	if err != nil {
+		return err
+	}
+
+	//閲覧が許可されたフォルダを含まないライブラリは返さない
+	libraryResponceList := make([]LibraryListLibraryResponce, 0)
+	for _, library := range config.GetLibraryList() {
+		if !access.CanBrowse(library.WatchDir) {
+			continue
+		}
+		rootFolder, err := db.SelectFolderRoot(ctx, library.WatchDir)
+		if err != nil {
+			return err
+		}
+		libraryResponceList = append(libraryResponceList, LibraryListLibraryResponce{
+			Name:      library.Name,
+			Hash:      rootFolder.Hash,
+			Direction: library.Direction,
+			Interval:  library.WatchInterval,
+		})
+	}
+
+	res := new(LibraryListResponce)
+	res.Count = len(libraryResponceList)
+	res.Libraries = libraryResponceList
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestLibraryListHandler(t *testing.T) {
+	c, rec := newTestContext(http.MethodPost, "/api/librarylist", nil, newTestLoginUser(t, testUser))
+	if code := responseCode(LibraryListHandler(c), rec); code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+
+	res := new(LibraryListResponce)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 1 || len(res.Libraries) != 1 {
+		t.Fatalf("count=%d libraries=%v", res.Count, res.Libraries)
+	}
+	library := res.Libraries[0]
+	if library.Name != testLibraryName || library.Hash != testHash(t, testLibraryDir) || library.Direction != "rtl" || library.Interval != 60 {
+		t.Errorf("library=%v", library)
+	}
+}
+
+func TestLibraryListHandlerGrant(t *testing.T) {
+	//許可されたフォルダを含むライブラリは返す
+	c, rec := newTestContext(http.MethodPost, "/api/librarylist", nil, newTestLoginUser(t, testGuestUser))
+	if code := responseCode(LibraryListHandler(c), rec); code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	res := new(LibraryListResponce)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 1 {
+		t.Errorf("guest count=%d", res.Count)
+	}
+
+	//ライブラリ外のフォルダだけを許可されたユーザーにはライブラリを返さない
+	c, rec = newTestContext(http.MethodPost, "/api/librarylist", nil, newTestLoginUser(t, testOutUser))
+	if code := responseCode(LibraryListHandler(c), rec); code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	res = new(LibraryListResponce)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 0 || len(res.Libraries) != 0 {
+		t.Errorf("outside count=%d libraries=%v", res.Count, res.Libraries)
+	}
+}
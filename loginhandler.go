@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo"
 
@@ -17,14 +21,56 @@ type LoginRequest struct {
 
 //LoginResponce はログインレスポンスデータ構造体
 type LoginResponce struct {
-	Token string `json:"token" xml:"token"`
+	Token              string `json:"token" xml:"token"`
+	ExpiresIn          int    `json:"expiresin" xml:"expiresin"` //トークンの有効時間（秒）
+	RefreshToken       string `json:"refreshtoken" xml:"refreshtoken"`
+	MustChangePassword bool   `json:"mustchangepassword" xml:"mustchangepassword"`
+}
+
+//RefreshRequest はトークン再発行・ログアウトリクエストデータ構造体
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshtoken" xml:"refreshtoken" form:"refreshtoken" query:"refreshtoken"`
+}
+
+//LogoutResponce はログアウトレスポンス構造体
+type LogoutResponce struct {
+	Status int `json:"status" xml:"status"`
+}
+
+//ChangePasswordRequest はパスワード変更リクエストデータ構造体
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldpassword" xml:"oldpassword" form:"oldpassword" query:"oldpassword"`
+	NewPassword string `json:"newpassword" xml:"newpassword" form:"newpassword" query:"newpassword"`
+}
+
+//ResetPasswordRequest はパスワードリセットリクエストデータ構造体
+type ResetPasswordRequest struct {
+	UserName string `json:"username" xml:"username" form:"username" query:"username"`
+	Password string `json:"password" xml:"password" form:"password" query:"password"`
+}
+
+//ResetPasswordResponce はパスワードリセットレスポンス構造体
+type ResetPasswordResponce struct {
+	Status int `json:"status" xml:"status"`
 }
 
 //CreateUserRequest はユーザー作成リクエストデータ構造体
 type CreateUserRequest struct {
-	UserName  string `json:"username" xml:"username" form:"username" query:"username"`
-	Password  string `json:"password" xml:"password" form:"password" query:"password"`
-	AuthLevel int    `json:"authlevel" xml:"authlevel" form:"authlevel" query:"authlevel"`
+	UserName     string `json:"username" xml:"username" form:"username" query:"username"`
+	Password     string `json:"password" xml:"password" form:"password" query:"password"`
+	AuthLevel    int    `json:"authlevel" xml:"authlevel" form:"authlevel" query:"authlevel"`
+	MaxAgeRating int    `json:"maxagerating" xml:"maxagerating" form:"maxagerating" query:"maxagerating"`
+}
+
+//SetAgeRatingRequest はユーザーの最大対象年齢設定リクエスト構造体
+type SetAgeRatingRequest struct {
+	UserName     string `json:"username" xml:"username" form:"username" query:"username"`
+	MaxAgeRating int    `json:"maxagerating" xml:"maxagerating" form:"maxagerating" query:"maxagerating"`
+}
+
+//SetAgeRatingResponce はユーザーの最大対象年齢設定レスポンス構造体
+type SetAgeRatingResponce struct {
+	Status int `json:"status" xml:"status"`
 }
 
 //CreateUserResponce はユーザー作成レスポンス構造体
@@ -50,8 +96,9 @@ type UserListResponce struct {
 
 //UserListUsersResponce はユーザー一覧取得レスポンス構造体
 type UserListUsersResponce struct {
-	UserName  string `json:"username" xml:"username"`
-	AuthLevel int    `json:"authlevel" xml:"authlevel"`
+	UserName     string `json:"username" xml:"username"`
+	AuthLevel    int    `json:"authlevel" xml:"authlevel"`
+	MaxAgeRating int    `json:"maxagerating" xml:"maxagerating"`
 }
 
 //LoginHandler はユーザーログインを行い、トークンを返す
@@ -60,82 +107,335 @@ func LoginHandler(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return err
 	}
-	fmt.Printf("request=%v\n", *req)
 
-	loginUser, err := NewLoginUserFromDB(req.UserName, req.Password)
+	//連続して失敗しているユーザー名・IPアドレスは待ち時間が過ぎるまでパスワードを確認しない
+	ip := clientIP(c)
+	if err := attemptLogin(c, "LoginHandler", req.UserName, ip); err != nil {
+		return err
+	}
+
+	loginUser, err := NewLoginUserFromDB(c.Request().Context(), req.UserName, req.Password)
 	if err != nil {
+		locked := loginLimiter.Failure(req.UserName, ip, time.Now())
+		fmt.Printf("LoginHandler failed username=%s ip=%s locked=%v err=%s\n", req.UserName, ip, locked, err)
 		return echo.NewHTTPError(http.StatusUnauthorized)
 	}
+	loginLimiter.Success(req.UserName, ip)
 
-	token, err := loginUser.CreateLoginToken()
+	res, err := newLoginResponce(c.Request().Context(), loginUser)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnauthorized)
 	}
 
-	fmt.Printf("token=%s\n", token)
+	fmt.Printf("LoginHandler success username=%s ip=%s\n", loginUser.UserName, ip)
+	return c.JSON(http.StatusOK, res)
+}
+
+//RefreshHandler はリフレッシュトークンからトークンを再発行する
+//使用したリフレッシュトークンは無効にし、新しいリフレッシュトークンを返す
+func RefreshHandler(c echo.Context) error {
+	req := new(RefreshRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	loginUser, err := NewLoginUserFromRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	//同じトークンで同時に再発行された時は、トークンを削除できた1つだけに新しいトークンを発行する
+	count, err := db.DeleteRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+
+	res, err := newLoginResponce(ctx, loginUser)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+//LogoutHandler は指定したリフレッシュトークンを無効にする
+//アクセストークンは有効期限まで使用できるため、すぐに無効にする時はLogoutAllHandlerを使用する
+func LogoutHandler(c echo.Context) error {
+	req := new(RefreshRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	loginUser := NewLoginUserFromRequest(c)
+	record, err := db.SelectRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return err
+	}
+	if record.ID != 0 && record.UserName == loginUser.UserName {
+		if _, err := db.DeleteRefreshToken(ctx, req.RefreshToken); err != nil {
+			return err
+		}
+	}
+
+	res := new(LogoutResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//LogoutAllHandler はログインユーザーの発行済みトークンをすべて無効にする
+func LogoutAllHandler(c echo.Context) error {
+	loginUser := NewLoginUserFromRequest(c)
+	err := db.RevokeUserToken(c.Request().Context(), loginUser.UserName)
+	if err != nil {
+		return err
+	}
+
+	res := new(LogoutResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//newLoginResponce はログイン情報からトークンとリフレッシュトークンを発行してレスポンスを生成する
+func newLoginResponce(ctx context.Context, loginUser *LoginUser) (*LoginResponce, error) {
+	token, err := loginUser.CreateLoginToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := loginUser.CreateRefreshToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	res := new(LoginResponce)
 	res.Token = token
+	res.ExpiresIn = int(accessTokenLimit().Seconds())
+	res.RefreshToken = refreshToken
+	res.MustChangePassword = loginUser.MustChangePassword
+	return res, nil
+}
+
+//ChangePasswordHandler はログインユーザーのパスワードを変更し、新しいトークンを返す（現在のパスワードが必要）
+func ChangePasswordHandler(c echo.Context) error {
+	req := new(ChangePasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	//現在のパスワードを総当たりで確認されないよう、ログインと同じ制限を行う
+	ctx := c.Request().Context()
+	loginUser := NewLoginUserFromRequest(c)
+	ip := clientIP(c)
+	if err := attemptLogin(c, "ChangePasswordHandler", loginUser.UserName, ip); err != nil {
+		return err
+	}
+	if _, err := NewLoginUserFromDB(ctx, loginUser.UserName, req.OldPassword); err != nil {
+		locked := loginLimiter.Failure(loginUser.UserName, ip, time.Now())
+		fmt.Printf("ChangePasswordHandler failed username=%s ip=%s locked=%v\n", loginUser.UserName, ip, locked)
+		return echo.NewHTTPError(http.StatusForbidden, "現在のパスワードが違う")
+	}
+	loginLimiter.Success(loginUser.UserName, ip)
+	if err := validateNewPassword(req.NewPassword); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.NewPassword == req.OldPassword {
+		return echo.NewHTTPError(http.StatusBadRequest, "現在のパスワードと同じパスワードには変更できない")
+	}
+
+	err := db.UpdateUserPassword(ctx, loginUser.UserName, req.NewPassword, false)
+	if err != nil {
+		return err
+	}
+	//変更前のパスワードで発行したトークンはすべて無効にする
+	err = db.RevokeUserToken(ctx, loginUser.UserName)
+	if err != nil {
+		return err
+	}
+
+	//現在のトークンも無効になるため、新しいトークンを返す
+	newLoginUser, err := NewLoginUserFromDB(ctx, loginUser.UserName, req.NewPassword)
+	if err != nil {
+		return err
+	}
+	res, err := newLoginResponce(ctx, newLoginUser)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, res)
 }
 
+//ResetPasswordHandler は指定したユーザーのパスワードをリセットする。リセットするには管理者権限が必要
+//リセットしたユーザーは次回ログイン時にパスワードの変更が必要になる
+func ResetPasswordHandler(c echo.Context) error {
+	req := new(ResetPasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("ResetPasswordHandler username=%s\n", req.UserName)
+
+	ctx := c.Request().Context()
+	user, err := db.SelectUser(ctx, req.UserName)
+	if err != nil || user.ID == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "指定したユーザーが見つからない")
+	}
+	if err := validateNewPassword(req.Password); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = db.UpdateUserPassword(ctx, user.Name, req.Password, true)
+	if err != nil {
+		return err
+	}
+	err = db.RevokeUserToken(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+
+	res := new(ResetPasswordResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//attemptLogin はパスワードを確認する前にログイン制限を確認し、待ち時間が必要な時は429エラーを返す
+func attemptLogin(c echo.Context, handlerName string, userName string, ip string) error {
+	wait := loginLimiter.Attempt(userName, ip, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	fmt.Printf("%s limited username=%s ip=%s wait=%s\n", handlerName, userName, ip, wait)
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "ログインの失敗が続いているためしばらく待つ必要がある")
+}
+
+//validateNewPassword は新しく設定するパスワードが条件を満たしているか確認する
+func validateNewPassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("パスワードは%d文字以上にする必要がある", minPasswordLength)
+	}
+	if password == DefaultAdminUserPassword {
+		return fmt.Errorf("デフォルトのパスワードは使用できない")
+	}
+	return nil
+}
+
 //CreateUserHandler は指定したユーザーを作成する
 func CreateUserHandler(c echo.Context) error {
 	req := new(CreateUserRequest)
 	if err := c.Bind(req); err != nil {
 		return err
 	}
-	fmt.Printf("request=%v\n", *req)
+	fmt.Printf("CreateUserHandler username=%s authlevel=%d\n", req.UserName, req.AuthLevel)
 
-	loginUser := NewLoginUserFromRequest(c)
-	if loginUser.AuthLevel != db.UserPermissionAdmin {
-		return fmt.Errorf("ログインユーザーが管理者権限を持っていない")
+	if req.UserName == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "ユーザー名が未入力")
+	}
+	if err := validateNewPassword(req.Password); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.AuthLevel != db.UserPermissionUser && req.AuthLevel != db.UserPermissionAdmin {
+		return echo.NewHTTPError(http.StatusBadRequest, "権限レベルが不正")
+	}
+	if req.MaxAgeRating < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "対象年齢が不正")
 	}
 
-	user, err := db.SelectUser(req.UserName)
+	ctx := c.Request().Context()
+	user, err := db.SelectUser(ctx, req.UserName)
 	if err == nil && user.ID != 0 {
-		return fmt.Errorf("すでにユーザーが存在する")
+		return echo.NewHTTPError(http.StatusConflict, "すでにユーザーが存在する")
 	}
 
-	err = db.InsertUser(req.UserName, req.Password, req.AuthLevel)
+	err = db.InsertUser(ctx, req.UserName, req.Password, req.AuthLevel, false)
 	if err != nil {
 		return err
 	}
+	if req.MaxAgeRating > 0 {
+		err = db.UpdateUserMaxAgeRating(ctx, req.UserName, req.MaxAgeRating)
+		if err != nil {
+			return err
+		}
+	}
 
 	res := new(CreateUserResponce)
 	res.Status = 0
 	return c.JSON(http.StatusOK, res)
 }
 
-//DeleteUserHandler は指定したユーザーを削除する。削除するには管理者権限が必要
-func DeleteUserHandler(c echo.Context) error {
-	req := new(DeleteUserRequest)
+//SetAgeRatingHandler は指定したユーザーが閲覧できる最大の対象年齢を設定する（0は制限なし）。設定するには管理者権限が必要
+func SetAgeRatingHandler(c echo.Context) error {
+	req := new(SetAgeRatingRequest)
 	if err := c.Bind(req); err != nil {
 		return err
 	}
-	fmt.Printf("request=%v\n", *req)
+	fmt.Printf("SetAgeRatingHandler username=%s maxagerating=%d\n", req.UserName, req.MaxAgeRating)
 
-	user, err := db.SelectUser(req.UserName)
+	if req.MaxAgeRating < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "対象年齢が不正")
+	}
+	ctx := c.Request().Context()
+	user, err := db.SelectUser(ctx, req.UserName)
 	if err != nil || user.ID == 0 {
-		return fmt.Errorf("削除するユーザーが見つからない")
+		return echo.NewHTTPError(http.StatusNotFound, "指定したユーザーが見つからない")
 	}
 
-	//トークンからユーザー名を取得
-	loginUser := NewLoginUserFromRequest(c)
-	if loginUser.AuthLevel != db.UserPermissionAdmin {
-		return fmt.Errorf("ログインユーザーが管理者権限を持っていない")
+	err = db.UpdateUserMaxAgeRating(ctx, user.Name, req.MaxAgeRating)
+	if err != nil {
+		return err
+	}
+
+	res := new(SetAgeRatingResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
+
+//DeleteUserHandler は指定したユーザーを削除する。削除するには管理者権限が必要
+func DeleteUserHandler(c echo.Context) error {
+	req := new(DeleteUserRequest)
+	if err := c.Bind(req); err != nil {
+		return err
 	}
+	fmt.Printf("DeleteUserHandler username=%s\n", req.UserName)
+
+	loginUser := NewLoginUserFromRequest(c)
 	if loginUser.UserName == req.UserName {
-		return fmt.Errorf("現在ログインしているユーザーは削除できない")
+		return echo.NewHTTPError(http.StatusBadRequest, "現在ログインしているユーザーは削除できない")
+	}
+
+	ctx := c.Request().Context()
+	user, err := db.SelectUser(ctx, req.UserName)
+	if err != nil || user.ID == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "削除するユーザーが見つからない")
 	}
 
 	//ユーザーを削除
-	err = db.DeleteUser(user.ID)
+	err = db.DeleteUser(ctx, user.ID)
 	if err != nil {
 		return err
 	}
 
 	//履歴も削除
-	err = db.DeleteHistory(user.Name)
+	err = db.DeleteHistory(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+
+	//リフレッシュトークンも削除（アクセストークンはユーザーが見つからなくなるため使用できない）
+	err = db.DeleteRefreshTokenOfUser(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+
+	//フォルダの閲覧許可も削除
+	err = db.DeleteFolderGrantOfUser(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+
+	//APIキーも削除
+	err = db.DeleteAPIKeyOfUser(ctx, user.Name)
 	if err != nil {
 		return err
 	}
@@ -147,7 +447,7 @@ func DeleteUserHandler(c echo.Context) error {
 
 //UserListHandler はユーザー一覧を取得する
 func UserListHandler(c echo.Context) error {
-	userList, err := db.SelectUserAll()
+	userList, err := db.SelectUserAll(c.Request().Context())
 	if err != nil {
 		return err
 	}
@@ -157,10 +457,81 @@ func UserListHandler(c echo.Context) error {
 	userResponceList := make([]UserListUsersResponce, 0)
 	for _, user := range userList {
 		userResponceList = append(userResponceList, UserListUsersResponce{
-			UserName:  user.Name,
-			AuthLevel: user.Permission,
+			UserName:     user.Name,
+			AuthLevel:    user.Permission,
+			MaxAgeRating: user.MaxAgeRating,
 		})
 	}
 	res.Users = userResponceList
 	return c.JSON(http.StatusOK, res)
 }
+
+//LockoutListResponce はログインロック一覧取得レスポンス構造体
+type LockoutListResponce struct {
+	Count    int                          `json:"count" xml:"count"`
+	Lockouts []LockoutListLockoutResponce `json:"lockouts" xml:"lockouts"`
+}
+
+//LockoutListLockoutResponce はログインロック一覧取得レスポンスのロック情報を保持する
+type LockoutListLockoutResponce struct {
+	Kind        string    `json:"kind" xml:"kind"`
+	Value       string    `json:"value" xml:"value"`
+	Failures    int       `json:"failures" xml:"failures"`
+	LockedUntil time.Time `json:"lockeduntil" xml:"lockeduntil"`
+}
+
+//UnlockRequest はログインロック解除リクエストデータ構造体
+type UnlockRequest struct {
+	UserName string `json:"username" xml:"username" form:"username" query:"username"`
+	IP       string `json:"ip" xml:"ip" form:"ip" query:"ip"`
+}
+
+//UnlockResponce はログインロック解除レスポンス構造体
+type UnlockResponce struct {
+	Status int `json:"status" xml:"status"`
+}
+
+//LockoutListHandler はログインの失敗が続いてロックしているユーザー名・IPアドレスの一覧を返す。取得するには管理者権限が必要
+func LockoutListHandler(c echo.Context) error {
+	lockoutResponceList := make([]LockoutListLockoutResponce, 0)
+	for _, lockout := range loginLimiter.LockoutList(time.Now()) {
+		lockoutResponceList = append(lockoutResponceList, LockoutListLockoutResponce{
+			Kind:        lockout.Kind,
+			Value:       lockout.Value,
+			Failures:    lockout.Count,
+			LockedUntil: lockout.LockedUntil.UTC(),
+		})
+	}
+
+	res := new(LockoutListResponce)
+	res.Count = len(lockoutResponceList)
+	res.Lockouts = lockoutResponceList
+	return c.JSON(http.StatusOK, res)
+}
+
+//UnlockHandler は指定したユーザー名・IPアドレスのログインの失敗回数とロックをクリアーする。解除するには管理者権限が必要
+func UnlockHandler(c echo.Context) error {
+	req := new(UnlockRequest)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	fmt.Printf("UnlockHandler username=%s ip=%s\n", req.UserName, req.IP)
+
+	if req.UserName == "" && req.IP == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "ユーザー名とIPアドレスのどちらかを指定する")
+	}
+	found := false
+	if req.UserName != "" && loginLimiter.Unlock(loginLimitKindUser, req.UserName) {
+		found = true
+	}
+	if req.IP != "" && loginLimiter.Unlock(loginLimitKindIP, req.IP) {
+		found = true
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "ログインの失敗が記録されていない")
+	}
+
+	res := new(UnlockResponce)
+	res.Status = 0
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//requestLogin はログインしてステータスコードとレスポンスを返す
+func requestLogin(t *testing.T, userName string, password string) (int, *LoginResponce) {
+	form := url.Values{}
+	form.Set("username", userName)
+	form.Set("password", password)
+	c, rec := newTestContext(http.MethodPost, "/login", form, nil)
+	code := responseCode(LoginHandler(c), rec)
+	if code != http.StatusOK {
+		return code, nil
+	}
+
+	res := new(LoginResponce)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	return code, res
+}
+
+//requestRefresh はリフレッシュトークンからトークンを再発行してステータスコードとレスポンスを返す
+func requestRefresh(t *testing.T, refreshToken string) (int, *LoginResponce) {
+	form := url.Values{}
+	form.Set("refreshtoken", refreshToken)
+	c, rec := newTestContext(http.MethodPost, "/refresh", form, nil)
+	code := responseCode(RefreshHandler(c), rec)
+	if code != http.StatusOK {
+		return code, nil
+	}
+
+	res := new(LoginResponce)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	return code, res
+}
+
+//requestWithToken はアクセストークンを認証してログインユーザーを読み込んだ後のステータスコードを返す
+func requestWithToken(token string) int {
+	c, rec := newTestContext(http.MethodPost, "/api/librarylist", nil, nil)
+	c.Request().Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	handler := NewJWTMiddleware()(LoadLoginUserMiddleware(LibraryListHandler))
+	return responseCode(handler(c), rec)
+}
+
+func TestLoginHandler(t *testing.T) {
+	loginLimiter = NewLoginLimiter()
+
+	code, res := requestLogin(t, testUser, testUserPassword)
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	if res.Token == "" || res.RefreshToken == "" || res.MustChangePassword {
+		t.Errorf("res=%v", res)
+	}
+	if code := requestWithToken(res.Token); code != http.StatusOK {
+		t.Errorf("token code=%d", code)
+	}
+}
+
+func TestLoginHandlerFailure(t *testing.T) {
+	loginLimiter = NewLoginLimiter()
+
+	if code, _ := requestLogin(t, testUser, "wrongpassword"); code != http.StatusUnauthorized {
+		t.Errorf("wrong password code=%d", code)
+	}
+
+	//失敗した直後は待ち時間が過ぎるまでパスワードを確認しない
+	form := url.Values{}
+	form.Set("username", testUser)
+	form.Set("password", testUserPassword)
+	c, rec := newTestContext(http.MethodPost, "/login", form, nil)
+	if code := responseCode(LoginHandler(c), rec); code != http.StatusTooManyRequests {
+		t.Errorf("retry code=%d", code)
+	}
+	if c.Response().Header().Get("Retry-After") == "" {
+		t.Errorf("Retry-After is empty")
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	loginLimiter = NewLoginLimiter()
+
+	_, login := requestLogin(t, testUser, testUserPassword)
+	if login == nil {
+		t.Fatal("login failed")
+	}
+	code, res := requestRefresh(t, login.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	if res.Token == "" || res.RefreshToken == "" || res.RefreshToken == login.RefreshToken {
+		t.Errorf("res=%v", res)
+	}
+
+	//使用したリフレッシュトークンは再使用できない
+	if code, _ := requestRefresh(t, login.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reuse code=%d", code)
+	}
+	if code, _ := requestRefresh(t, res.RefreshToken); code != http.StatusOK {
+		t.Errorf("new token code=%d", code)
+	}
+}
+
+func TestLogoutHandler(t *testing.T) {
+	loginLimiter = NewLoginLimiter()
+
+	_, login := requestLogin(t, testUser, testUserPassword)
+	if login == nil {
+		t.Fatal("login failed")
+	}
+	form := url.Values{}
+	form.Set("refreshtoken", login.RefreshToken)
+	c, rec := newTestContext(http.MethodPost, "/api/logout", form, newTestLoginUser(t, testUser))
+	if code := responseCode(LogoutHandler(c), rec); code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+
+	//ログアウトしたリフレッシュトークンは使用できない
+	if code, _ := requestRefresh(t, login.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh code=%d", code)
+	}
+}
+
+func TestLoadLoginUserMiddlewareRecreatedUser(t *testing.T) {
+	loginLimiter = NewLoginLimiter()
+
+	ctx := context.Background()
+	userName := testUser
+	_, login := requestLogin(t, userName, testUserPassword)
+	if login == nil {
+		t.Fatal("login failed")
+	}
+
+	//削除して同じ名前で作成し直したユーザーには削除前のトークンを使用させない
+	user, err := db.SelectUser(ctx, userName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertUser(ctx, userName, testUserPassword, db.UserPermissionUser, false); err != nil {
+		t.Fatal(err)
+	}
+	if code := requestWithToken(login.Token); code != http.StatusUnauthorized {
+		t.Errorf("code=%d", code)
+	}
+}
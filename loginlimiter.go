@@ -0,0 +1,250 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+var (
+	loginLimiter = NewLoginLimiter() //ログイン失敗回数の記録
+)
+
+//ログイン失敗を記録する対象の種類
+const (
+	loginLimitKindUser = "user"
+	loginLimitKindIP   = "ip"
+)
+
+//LoginLimiter はユーザー名・IPアドレスごとのログインの連続失敗回数を保持し、総当たりによるログインを制限する
+//失敗するたびに次のログインを受け付けるまでの待ち時間を2倍にし、失敗回数が上限に達すると一定時間ロックする
+//記録はメモリ上だけに保持する（再起動するとクリアーされる）
+type LoginLimiter struct {
+	mutex      *sync.Mutex
+	failureMap map[string]*loginFailure
+}
+
+//loginFailure はユーザー名またはIPアドレスの連続失敗情報を保持する
+type loginFailure struct {
+	Kind         string
+	Value        string
+	Count        int
+	LastFailure  time.Time
+	LockedUntil  time.Time
+	Pending      int       //受け付けてパスワードを確認している数（確認中は失敗として扱う）
+	PendingSince time.Time //最後に受け付けた日時
+}
+
+//LoginLockout はロック中のユーザー名・IPアドレスの情報を保持する
+type LoginLockout struct {
+	Kind        string
+	Value       string
+	Count       int
+	LockedUntil time.Time
+}
+
+//NewLoginLimiter はログイン失敗回数の記録を生成する
+func NewLoginLimiter() *LoginLimiter {
+	limiter := new(LoginLimiter)
+	limiter.mutex = new(sync.Mutex)
+	limiter.failureMap = make(map[string]*loginFailure)
+	return limiter
+}
+
+//Attempt は指定したユーザー名・IPアドレスのログインを受け付けるかどうかを確認し、受け付ける時は確認中として記録する
+//受け付けない時は次のログインを受け付けるまでの待ち時間を返す（0の時は受け付けた）
+//パスワードの確認には時間がかかるため、同時に送られたログインがすべて受け付けられないよう、確認と記録は1回のロックで行い
+//確認中のログインは失敗したものとして待ち時間を計算する
+//受け付けた時はパスワードの確認後に必ずFailureまたはSuccessを呼び出すこと
+func (limiter *LoginLimiter) Attempt(userName string, ip string, now time.Time) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.removeExpired(now)
+	keyList := loginLimitKeyList(userName, ip)
+	wait := time.Duration(0)
+	for _, key := range keyList {
+		failure, ok := limiter.failureMap[key]
+		if !ok {
+			continue
+		}
+		if failureWait := failure.wait(now); failureWait > wait {
+			wait = failureWait
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for _, key := range keyList {
+		failure, ok := limiter.failureMap[key]
+		if !ok {
+			failure = new(loginFailure)
+			failure.Kind, failure.Value = splitLoginLimitKey(key)
+			limiter.failureMap[key] = failure
+		}
+		failure.Pending++
+		failure.PendingSince = now
+	}
+	return 0
+}
+
+//Failure はAttemptで受け付けたログインの失敗を確定し、失敗回数が上限に達した時はロックする。ロックした時はtrueを返す
+func (limiter *LoginLimiter) Failure(userName string, ip string, now time.Time) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	locked := false
+	for _, key := range loginLimitKeyList(userName, ip) {
+		failure, ok := limiter.failureMap[key]
+		if !ok {
+			//確認中に管理者がロックを解除した
+			continue
+		}
+		failure.finishPending()
+		failure.Count++
+		failure.LastFailure = now
+
+		maxFailures := config.GetConfig().Login.LoginMaxFailures
+		if failure.Kind == loginLimitKindIP {
+			maxFailures = config.GetConfig().Login.LoginMaxFailuresPerIP
+		}
+		if maxFailures > 0 && failure.Count >= maxFailures {
+			failure.LockedUntil = now.Add(loginLockoutLimit())
+			locked = true
+		}
+	}
+	return locked
+}
+
+//Success はAttemptで受け付けたログインの成功を記録し、ユーザー名の失敗回数をクリアーする
+//IPアドレスの失敗回数は、同じIPアドレスから別のユーザーへの総当たりを続けられないようにクリアーしない
+func (limiter *LoginLimiter) Success(userName string, ip string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	delete(limiter.failureMap, loginLimitKindUser+":"+userName)
+	key := loginLimitKindIP + ":" + ip
+	if failure, ok := limiter.failureMap[key]; ok {
+		failure.finishPending()
+		if failure.Count == 0 && failure.Pending == 0 {
+			delete(limiter.failureMap, key)
+		}
+	}
+}
+
+//Unlock は指定した種類・値の失敗回数とロックをクリアーする（記録がない時はfalseを返す）
+func (limiter *LoginLimiter) Unlock(kind string, value string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	key := kind + ":" + value
+	if _, ok := limiter.failureMap[key]; !ok {
+		return false
+	}
+	delete(limiter.failureMap, key)
+	return true
+}
+
+//LockoutList はロック中のユーザー名・IPアドレスの一覧を返す
+func (limiter *LoginLimiter) LockoutList(now time.Time) []LoginLockout {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	lockoutList := make([]LoginLockout, 0)
+	for _, failure := range limiter.failureMap {
+		if !failure.LockedUntil.After(now) {
+			continue
+		}
+		lockoutList = append(lockoutList, LoginLockout{
+			Kind:        failure.Kind,
+			Value:       failure.Value,
+			Count:       failure.Count,
+			LockedUntil: failure.LockedUntil,
+		})
+	}
+	sort.Slice(lockoutList, func(i, j int) bool {
+		return lockoutList[i].LockedUntil.Before(lockoutList[j].LockedUntil)
+	})
+	return lockoutList
+}
+
+//removeExpired はロックが解除され、最後の失敗からロックする時間以上経過した記録を削除する（ロックした状態で呼び出すこと）
+func (limiter *LoginLimiter) removeExpired(now time.Time) {
+	for key, failure := range limiter.failureMap {
+		if failure.LockedUntil.Before(now) && now.Sub(failure.lastTime()) > loginLockoutLimit() {
+			delete(limiter.failureMap, key)
+		}
+	}
+}
+
+//wait は次のログインを受け付けるまでの待ち時間を返す（確認中のログインは失敗したものとして扱う）
+func (failure *loginFailure) wait(now time.Time) time.Duration {
+	if failure.LockedUntil.After(now) {
+		return failure.LockedUntil.Sub(now)
+	}
+	count := failure.Count + failure.Pending
+	if count == 0 {
+		return 0
+	}
+
+	//失敗するたびに待ち時間を2倍にする（ロックする時間を上限とする）
+	backoff := time.Duration(config.GetConfig().Login.LoginBackoffSeconds) * time.Second
+	for i := 1; i < count && backoff < loginLockoutLimit(); i++ {
+		backoff *= 2
+	}
+	if backoff > loginLockoutLimit() {
+		backoff = loginLockoutLimit()
+	}
+	if wait := failure.lastTime().Add(backoff).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+//lastTime は最後に失敗または受け付けた日時を返す
+func (failure *loginFailure) lastTime() time.Time {
+	if failure.PendingSince.After(failure.LastFailure) {
+		return failure.PendingSince
+	}
+	return failure.LastFailure
+}
+
+//finishPending は確認中のログインを1つ終了する
+func (failure *loginFailure) finishPending() {
+	if failure.Pending > 0 {
+		failure.Pending--
+	}
+	if failure.Pending == 0 {
+		failure.PendingSince = time.Time{}
+	}
+}
+
+//loginLimitKeyList は失敗回数を記録するキーの一覧を返す
+func loginLimitKeyList(userName string, ip string) []string {
+	keyList := make([]string, 0)
+	if userName != "" {
+		keyList = append(keyList, loginLimitKindUser+":"+userName)
+	}
+	if ip != "" {
+		keyList = append(keyList, loginLimitKindIP+":"+ip)
+	}
+	return keyList
+}
+
+//splitLoginLimitKey はキーを種類と値に分割する
+func splitLoginLimitKey(key string) (string, string) {
+	splitList := strings.SplitN(key, ":", 2)
+	if len(splitList) != 2 {
+		return "", key
+	}
+	return splitList[0], splitList[1]
+}
+
+//loginLockoutLimit はロックする時間を返す
+func loginLockoutLimit() time.Duration {
+	return time.Duration(config.GetConfig().Login.LoginLockoutMinutes) * time.Minute
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+const (
+	testLimitUser = "limituser"
+	testLimitIP   = "192.0.2.10"
+)
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	limiter := NewLoginLimiter()
+	backoff := time.Duration(config.GetConfig().Login.LoginBackoffSeconds) * time.Second
+	now := time.Unix(1500000000, 0)
+
+	//失敗するたびに待ち時間が2倍になる
+	for i := 0; i < 3; i++ {
+		if wait := limiter.Attempt(testLimitUser, testLimitIP, now); wait != 0 {
+			t.Fatalf("%d: wait=%s", i, wait)
+		}
+		limiter.Failure(testLimitUser, testLimitIP, now)
+
+		expected := backoff << uint(i)
+		if wait := limiter.Attempt(testLimitUser, testLimitIP, now); wait != expected {
+			t.Errorf("%d: wait=%s expected=%s", i, wait, expected)
+		}
+		if wait := limiter.Attempt(testLimitUser, testLimitIP, now.Add(expected-time.Millisecond)); wait != time.Millisecond {
+			t.Errorf("%d: wait=%s before backoff", i, wait)
+		}
+		now = now.Add(expected)
+	}
+
+	//成功するとユーザー名の失敗回数はクリアーされ、IPアドレスの失敗回数は残る
+	if wait := limiter.Attempt(testLimitUser, testLimitIP, now); wait != 0 {
+		t.Fatalf("wait=%s", wait)
+	}
+	limiter.Success(testLimitUser, testLimitIP)
+	if wait := limiter.Attempt(testLimitUser, "", now); wait != 0 {
+		t.Errorf("user wait=%s after success", wait)
+	}
+	limiter.Success(testLimitUser, "")
+	if wait := limiter.Attempt("", testLimitIP, now); wait != 0 {
+		t.Fatalf("ip wait=%s", wait)
+	}
+	limiter.Failure("", testLimitIP, now)
+	if wait := limiter.Attempt("", testLimitIP, now); wait != backoff<<3 {
+		t.Errorf("ip wait=%s after success", wait)
+	}
+}
+
+func TestLoginLimiterLockout(t *testing.T) {
+	limiter := NewLoginLimiter()
+	maxFailures := config.GetConfig().Login.LoginMaxFailures
+	now := time.Unix(1500000000, 0)
+
+	//上限に達するまで待ち時間が過ぎるたびに失敗する
+	for i := 1; i <= maxFailures; i++ {
+		wait := limiter.Attempt(testLimitUser, testLimitIP, now)
+		if wait != 0 {
+			t.Fatalf("%d: wait=%s", i, wait)
+		}
+		locked := limiter.Failure(testLimitUser, testLimitIP, now)
+		if locked != (i == maxFailures) {
+			t.Errorf("%d: locked=%v", i, locked)
+		}
+		now = now.Add(limiter.Attempt(testLimitUser, testLimitIP, now))
+	}
+	if wait := limiter.Attempt(testLimitUser, testLimitIP, now.Add(-loginLockoutLimit())); wait != loginLockoutLimit() {
+		t.Errorf("wait=%s", wait)
+	}
+	lockoutList := limiter.LockoutList(now.Add(-time.Second))
+	if len(lockoutList) != 1 || lockoutList[0].Kind != loginLimitKindUser || lockoutList[0].Value != testLimitUser || lockoutList[0].Count != maxFailures {
+		t.Errorf("lockout=%v", lockoutList)
+	}
+
+	//ロックを解除すると別のIPアドレスからログインできる
+	if !limiter.Unlock(loginLimitKindUser, testLimitUser) {
+		t.Errorf("unlock failed")
+	}
+	if wait := limiter.Attempt(testLimitUser, "192.0.2.11", now); wait != 0 {
+		t.Errorf("wait=%s after unlock", wait)
+	}
+}
+
+func TestLoginLimiterPending(t *testing.T) {
+	limiter := NewLoginLimiter()
+	now := time.Unix(1500000000, 0)
+
+	//パスワードの確認中は同じユーザー名・IPアドレスのログインを受け付けない
+	if wait := limiter.Attempt(testLimitUser, testLimitIP, now); wait != 0 {
+		t.Fatalf("wait=%s", wait)
+	}
+	if wait := limiter.Attempt(testLimitUser, "192.0.2.11", now); wait == 0 {
+		t.Errorf("same user wait=0")
+	}
+	if wait := limiter.Attempt("otheruser", testLimitIP, now); wait == 0 {
+		t.Errorf("same ip wait=0")
+	}
+
+	//成功すると確認中の記録がなくなる
+	limiter.Success(testLimitUser, testLimitIP)
+	if wait := limiter.Attempt(testLimitUser, testLimitIP, now); wait != 0 {
+		t.Errorf("wait=%s after success", wait)
+	}
+}
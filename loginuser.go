@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strconv"
 	"time"
 
@@ -13,63 +14,129 @@ import (
 )
 
 const (
-	//TokenLimitHour トークンの有効時間
-	tokenLimitHour = 48
-
 	//DefaultAdminUserName はデフォルトの管理者名
 	DefaultAdminUserName = "admin"
 
-	//DefaultAdminUserPassword はデフォルトの管理者パスワード
+	//DefaultAdminUserPassword はデフォルトの管理者パスワード（初回ログイン時に変更が必要）
 	DefaultAdminUserPassword = "p@ssword"
+
+	//minPasswordLength は変更・リセット時に設定できるパスワードの最小文字数
+	minPasswordLength = 8
 )
 
 //LoginUser はログインユーザーのログイン情報を保持する
 type LoginUser struct {
-	UserName  string
-	AuthLevel int
+	UserID             int64 //同じ名前で作成し直したユーザーに削除前のトークンを使用させないために確認する
+	UserName           string
+	AuthLevel          int
+	MustChangePassword bool   //パスワードを変更するまでパスワード変更以外の操作はできない
+	TokenVersion       int    //DBのユーザー情報と一致しないトークンは無効
+	MaxAgeRating       int    //閲覧できる最大の対象年齢（0は制限なし）
+	APIKeyScope        string //APIキーでログインした時のキーの権限範囲（トークンでログインした時は空）
 }
 
-//NewLoginUserFromRequest はechoのJWTリクエストヘッダ情報からログイン情報を取得して返す
+//NewLoginUserFromRequest はLoadLoginUserMiddlewareがDBから取得したログイン情報を返す
 func NewLoginUserFromRequest(c echo.Context) *LoginUser {
-	tokenUser := c.Get("user").(*jwt.Token)
-	claims := tokenUser.Claims.(jwt.MapClaims)
-
-	loginUser := new(LoginUser)
-	loginUser.UserName = claims["name"].(string)
-	loginUser.AuthLevel, _ = strconv.Atoi(claims["authlevel"].(string))
-	return loginUser
+	return c.Get(loginUserContextKey).(*LoginUser)
 }
 
 //NewLoginUserFromDB は指定したユーザー情報からDBを検索してログイン情報を取得して返す
-func NewLoginUserFromDB(userName string, password string) (*LoginUser, error) {
+func NewLoginUserFromDB(ctx context.Context, userName string, password string) (*LoginUser, error) {
 	if userName == "" || password == "" {
 		return nil, fmt.Errorf("ユーザー名またはパスワード入力なし")
 	}
 
-	user, err := db.SelectUser(userName)
-	if err != nil {
+	user, err := db.SelectUser(ctx, userName)
+	if err != nil || user.ID == 0 {
+		//ユーザーが存在するかどうかを応答時間で判別されないよう、存在しない時もハッシュを生成する
+		db.CreatePasswordHash(password)
 		return nil, fmt.Errorf("指定されたユーザー名が見つからない")
 	}
 
-	passHash := db.CreatePasswordHash(password)
-	if user.PassHash != passHash {
+	ok, needRehash := db.VerifyPassword(user.PassHash, password)
+	if !ok {
 		return nil, fmt.Errorf("パスワードが違う")
 	}
+	if password == DefaultAdminUserPassword && !user.MustChangePassword {
+		//変更前のバージョンで登録されたデフォルトパスワードのままのユーザーにも変更を求める
+		user.MustChangePassword = true
+		needRehash = true
+	}
+	if needRehash {
+		//旧形式・設定変更前のハッシュはログイン成功時に現在の設定で生成し直す
+		if err := db.UpdateUserPassword(ctx, user.Name, password, user.MustChangePassword); err != nil {
+			fmt.Printf("NewLoginUserFromDB rehash err=%s\n", err)
+		}
+	}
+
+	return newLoginUserFromTable(user), nil
+}
+
+//NewLoginUserFromRefreshToken はリフレッシュトークンからログイン情報を取得して返す
+func NewLoginUserFromRefreshToken(ctx context.Context, refreshToken string) (*LoginUser, error) {
+	if refreshToken == "" {
+		return nil, fmt.Errorf("リフレッシュトークン入力なし")
+	}
+
+	record, err := db.SelectRefreshToken(ctx, refreshToken)
+	if err != nil || record.ID == 0 {
+		return nil, fmt.Errorf("リフレッシュトークンが見つからない")
+	}
+	if record.ExpiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("リフレッシュトークンの有効期限切れ")
+	}
+
+	user, err := db.SelectUser(ctx, record.UserName)
+	if err != nil || user.ID == 0 {
+		return nil, fmt.Errorf("指定されたユーザー名が見つからない")
+	}
+	return newLoginUserFromTable(user), nil
+}
+
+//NewLoginUserFromAPIKey はAPIキーからログイン情報を取得して返す
+func NewLoginUserFromAPIKey(ctx context.Context, key string) (*LoginUser, error) {
+	if key == "" {
+		return nil, fmt.Errorf("APIキー入力なし")
+	}
+
+	record, err := db.SelectAPIKey(ctx, key)
+	if err != nil || record.ID == 0 {
+		return nil, fmt.Errorf("APIキーが見つからない")
+	}
+	if record.IsExpired(time.Now()) {
+		return nil, fmt.Errorf("APIキーの有効期限切れ")
+	}
+
+	user, err := db.SelectUser(ctx, record.UserName)
+	if err != nil || user.ID == 0 {
+		return nil, fmt.Errorf("指定されたユーザー名が見つからない")
+	}
+	loginUser := newLoginUserFromTable(user)
+	loginUser.APIKeyScope = record.Scope
+	return loginUser, nil
+}
 
+//newLoginUserFromTable はDBのユーザー情報からログイン情報を生成する
+func newLoginUserFromTable(user db.UserTable) *LoginUser {
 	loginUser := new(LoginUser)
-	loginUser.UserName = userName
+	loginUser.UserID = user.ID
+	loginUser.UserName = user.Name
 	loginUser.AuthLevel = user.Permission
-	return loginUser, nil
+	loginUser.MustChangePassword = user.MustChangePassword
+	loginUser.TokenVersion = user.TokenVersion
+	loginUser.MaxAgeRating = user.MaxAgeRating
+	return loginUser
 }
 
 //CreateDefaultAdminUser はユーザーが1件も登録されていないときはデフォルトの管理者ユーザーを登録する
-func CreateDefaultAdminUser() error {
-	users, err := db.SelectUserAll()
+//デフォルトのパスワードは公開されているため、初回ログイン時にパスワードの変更を必要とする
+func CreateDefaultAdminUser(ctx context.Context) error {
+	users, err := db.SelectUserAll(ctx)
 	if len(users) > 0 {
 		return fmt.Errorf("すでにユーザーは存在するので作成しない")
 	}
 
-	err = db.InsertUser(DefaultAdminUserName, DefaultAdminUserPassword, db.UserPermissionAdmin)
+	err = db.InsertUser(ctx, DefaultAdminUserName, DefaultAdminUserPassword, db.UserPermissionAdmin, true)
 	if err != nil {
 		return err
 	}
@@ -84,8 +151,13 @@ func (loginUser *LoginUser) CreateLoginToken() (string, error) {
 	//マップに設定
 	claims := token.Claims.(jwt.MapClaims)
 	claims["name"] = loginUser.UserName
+	claims["uid"] = strconv.FormatInt(loginUser.UserID, 10)
 	claims["authlevel"] = strconv.Itoa(loginUser.AuthLevel)
-	claims["exp"] = time.Now().Add(time.Hour * tokenLimitHour).Unix()
+	if loginUser.MustChangePassword {
+		claims["mustchange"] = "1"
+	}
+	claims["ver"] = strconv.Itoa(loginUser.TokenVersion)
+	claims["exp"] = time.Now().Add(accessTokenLimit()).Unix()
 
 	//トークン取得
 	t, err := token.SignedString([]byte(config.GetConfig().Login.TokenSalt))
@@ -95,3 +167,19 @@ func (loginUser *LoginUser) CreateLoginToken() (string, error) {
 
 	return t, nil
 }
+
+//CreateRefreshToken は現在のログイン情報でリフレッシュトークンを生成して登録し、トークンを返す
+func (loginUser *LoginUser) CreateRefreshToken(ctx context.Context) (string, error) {
+	//期限切れのトークンが溜まらないよう発行時に片付ける
+	if err := db.DeleteRefreshTokenExpired(ctx); err != nil {
+		fmt.Printf("CreateRefreshToken err=%s\n", err)
+	}
+
+	days := config.GetConfig().Login.RefreshTokenDays
+	return db.CreateRefreshToken(ctx, loginUser.UserName, time.Now().AddDate(0, 0, days))
+}
+
+//accessTokenLimit はアクセストークンの有効時間を返す
+func accessTokenLimit() time.Duration {
+	return time.Minute * time.Duration(config.GetConfig().Login.AccessTokenMinutes)
+}
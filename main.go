@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,32 +13,136 @@ import (
 	"github.com/robfig/cron"
 
 	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+var (
+	noMigrateFlag     = flag.Bool("no-migrate", false, "起動時にDBのマイグレーションを行わない")
+	migrateStatusFlag = flag.Bool("migrate-status", false, "DBのマイグレーション適用状況を表示して終了する")
+	demoFlag          = flag.Bool("demo", false, "DBを使用せずメモリ上にデータを保持して起動する（終了時に破棄）")
 )
 
 func main() {
+	flag.Parse()
+
 	//環境設定読み込み
 	if !config.LoadConfig() {
 		log.Println("設定ファイル読み込み失敗（デフォルト値動作）")
 	}
-	CreateDefaultAdminUser()
+
+	//DB接続は起動時に1つだけ生成して共有する
+	store, err := openStore()
+	if err != nil {
+		log.Fatalf("DB接続失敗 err=%s\n", err)
+	}
+	defer store.Close()
+	db.SetStore(store)
+	if *migrateStatusFlag {
+		printMigrationList()
+		return
+	}
+	startMigrate()
+
+	CreateDefaultAdminUser(context.Background())
+	startCheckConsistency()
 	startCrontab()
 	startEchoServer()
 }
 
+func openStore() (db.Store, error) {
+	if *demoFlag {
+		log.Println("デモモードで起動（登録データは終了時に破棄される）")
+		return db.NewMemoryStore(), nil
+	}
+	return db.OpenStore(config.GetConfig().DB)
+}
+
+func startMigrate() {
+	ctx := context.Background()
+	if *noMigrateFlag {
+		//適用しないが未適用のものがあることは知らせる
+		migrationList, err := db.SelectMigrationList(ctx)
+		if err != nil {
+			log.Fatalf("マイグレーション確認失敗 err=%s\n", err)
+		}
+		for _, migration := range migrationList {
+			if !migration.Applied {
+				log.Printf("未適用のマイグレーションあり version=%d name=%s\n", migration.Version, migration.Name)
+			}
+		}
+		return
+	}
+
+	appliedList, err := db.Migrate(ctx)
+	if err != nil {
+		log.Fatalf("マイグレーション失敗 err=%s\n", err)
+	}
+	for _, migration := range appliedList {
+		log.Printf("マイグレーション適用 version=%d name=%s\n", migration.Version, migration.Name)
+	}
+}
+
+func printMigrationList() {
+	migrationList, err := db.SelectMigrationList(context.Background())
+	if err != nil {
+		log.Fatalf("マイグレーション確認失敗 err=%s\n", err)
+	}
+
+	for _, migration := range migrationList {
+		status := "pending"
+		appliedAt := ""
+		if migration.Applied {
+			status = "applied"
+			appliedAt = migration.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d\t%-8s\t%s\t%s\n", migration.Version, status, migration.Name, appliedAt)
+	}
+}
+
+func startCheckConsistency() {
+	result, err := checkConsistency(context.Background())
+	if err != nil {
+		log.Printf("整合性確認失敗 err=%s\n", err)
+		return
+	}
+	log.Printf("整合性確認 folderRelink=%d bookRelink=%d bookRemove=%d historyRemove=%d thumbRemove=%d\n",
+		result.FolderRelinkCount, result.BookRelinkCount, result.BookRemoveCount, result.HistoryRemoveCount, result.ThumbRemoveCount)
+}
+
 func startCrontab() {
-	interval := config.GetConfig().File.WatchInterval
 	fileWatcher = NewFileWatcher()
-	if interval > 0 {
-		c := cron.New()
-		format := fmt.Sprintf("0 */%d * * * *", config.GetConfig().File.WatchInterval) //分単位指定
+	c := cron.New()
+	dirList := make([]string, 0)
+	for _, library := range config.GetLibraryList() {
+		dirList = append(dirList, library.WatchDir)
+		if library.WatchInterval <= 0 {
+			continue
+		}
+
+		//ライブラリごとの間隔で定期実行する（同時に実行時刻になったライブラリは順番に探索する）
+		watchDir := library.WatchDir
+		format := fmt.Sprintf("0 */%d * * * *", library.WatchInterval) //分単位指定
 		c.AddFunc(format, func() {
-			fileWatcher.StartBackgroundTask()
+			fileWatcher.QueueScan(watchDir)
 		})
-		c.Start()
 	}
+	c.Start()
 
 	//起動時は最初に処理を実行する
 	fileWatcher.StartBackgroundTask()
+
+	//変更通知を使用できる時は定期実行を待たずに変更を反映する（使用できない時は定期実行のみ）
+	if config.GetConfig().File.NotifyEnabled {
+		notifyWatcher, err := NewNotifyWatcher(fileWatcher)
+		if err != nil {
+			log.Printf("変更通知の監視を開始できない（定期実行のみ） err=%s\n", err)
+			return
+		}
+		if err := notifyWatcher.Start(dirList); err != nil {
+			log.Printf("変更通知の監視を開始できない（定期実行のみ） err=%s\n", err)
+			notifyWatcher.Close()
+		}
+	}
 }
 
 func startEchoServer() {
@@ -47,7 +153,13 @@ func startEchoServer() {
 	}
 	switch config.GetConfig().Log.Output {
 	case "stream":
-		e.Use(middleware.Logger())
+		//パスワードなどがクエリーで指定された時に出力しないよう、URIではなくパスを出力する
+		e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+			Format: `{"time":"${time_rfc3339_nano}","id":"${id}","remote_ip":"${remote_ip}",` +
+				`"host":"${host}","method":"${method}","path":"${path}","user_agent":"${user_agent}",` +
+				`"status":${status},"error":"${error}","latency":${latency},"latency_human":"${latency_human}"` +
+				`,"bytes_in":${bytes_in},"bytes_out":${bytes_out}}` + "\n",
+		}))
 	case "file":
 		//未実装
 	}
@@ -57,23 +169,57 @@ func startEchoServer() {
 		return c.String(http.StatusOK, "squidgirl-go")
 	})
 	e.POST("/login", LoginHandler)
+	e.POST("/refresh", RefreshHandler)
 
+	//api以下はログインが必要で、権限はリクエストごとにDBから取得したユーザー情報で確認する
 	apiGroup := e.Group("/api")
-	apiGroup.Use(middleware.JWT([]byte(config.GetConfig().Login.TokenSalt)))
+	apiGroup.Use(NewJWTMiddleware())
+	apiGroup.Use(LoadLoginUserMiddleware)
+	apiGroup.Use(PasswordChangeMiddleware)
+	adminOnly := RequirePermissionMiddleware(db.UserPermissionAdmin)
+	writeScope := RequireWriteScopeMiddleware //読み取り専用のAPIキーでは使用できない
 
 	//ファイル関連
+	apiGroup.GET("/libraries", LibraryListHandler)
 	apiGroup.POST("/filelist", FileListHandler)
 	apiGroup.POST("/parentlist", ParentListHandler)
 	apiGroup.GET("/thumbnail/:hash", ThumbnailHandler)
 	apiGroup.GET("/page/:hash", PageHandler)
 
 	//既読情報
-	apiGroup.POST("/savebook", SaveBookHandler)
+	apiGroup.POST("/savebook", SaveBookHandler, writeScope)
 
 	//ユーザー関連
-	apiGroup.GET("/userlist", UserListHandler)
-	apiGroup.POST("/createuser", CreateUserHandler)
-	apiGroup.POST("/deleteuser", DeleteUserHandler)
+	apiGroup.POST("/changepassword", ChangePasswordHandler, writeScope)
+	apiGroup.POST("/logout", LogoutHandler)
+	apiGroup.POST("/logoutall", LogoutAllHandler, writeScope)
+
+	//APIキー
+	apiGroup.GET("/apikeys", APIKeyListHandler)
+	apiGroup.POST("/apikeys/create", APIKeyCreateHandler, writeScope)
+	apiGroup.POST("/apikeys/delete", APIKeyDeleteHandler, writeScope)
+
+	//ユーザー管理（管理者のみ）
+	apiGroup.GET("/userlist", UserListHandler, adminOnly)
+	apiGroup.POST("/createuser", CreateUserHandler, adminOnly, writeScope)
+	apiGroup.POST("/deleteuser", DeleteUserHandler, adminOnly, writeScope)
+	apiGroup.POST("/resetpassword", ResetPasswordHandler, adminOnly, writeScope)
+	apiGroup.POST("/setagerating", SetAgeRatingHandler, adminOnly, writeScope)
+
+	//管理（管理者のみ）
+	adminGroup := apiGroup.Group("/admin", adminOnly)
+	adminGroup.GET("/scan", ScanStatusHandler)
+	adminGroup.POST("/scan/start", ScanStartHandler, writeScope)
+	adminGroup.POST("/scan/cancel", ScanCancelHandler, writeScope)
+	adminGroup.GET("/problems", ProblemListHandler)
+	adminGroup.GET("/duplicates", DuplicateListHandler)
+	adminGroup.GET("/grants", GrantListHandler)
+	adminGroup.POST("/grants/add", GrantAddHandler, writeScope)
+	adminGroup.POST("/grants/delete", GrantDeleteHandler, writeScope)
+	adminGroup.GET("/ratings", RatingListHandler)
+	adminGroup.POST("/ratings/set", RatingSetHandler, writeScope)
+	adminGroup.GET("/lockouts", LockoutListHandler)
+	adminGroup.POST("/unlock", UnlockHandler, writeScope)
 
 	//開始
 	e.Logger.Fatal(e.Start(":" + strconv.Itoa(config.GetConfig().Server.PortNum)))
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//テスト用ライブラリの名前とテストユーザーのパスワード
+const (
+	testLibraryName  = "テスト"
+	testUserPassword = "password123"
+)
+
+//テスト用ライブラリの構成（TestMainで作成する）
+var (
+	testLibraryDir string //ライブラリのルートフォルダ
+	testOpenDir    string //誰でも閲覧できるフォルダ
+	testPrivateDir string //testGuestUserには閲覧を許可しないフォルダ
+	testOpenBook   string //testOpenDir内のアーカイブ（対象年齢なし）
+	testTeenBook   string //testOpenDir内のアーカイブ（ComicInfo.xmlで13歳以上）
+	testSecretBook string //testPrivateDir内のアーカイブ
+)
+
+//テストユーザー
+const (
+	testAdminUser = "testadmin" //管理者
+	testUser      = "testuser"  //制限なしの一般ユーザー
+	testGuestUser = "testguest" //testOpenDirだけ閲覧を許可したユーザー
+	testKidUser   = "testkid"   //最大の対象年齢を12歳にしたユーザー
+	testOutUser   = "testout"   //ライブラリ外のフォルダだけ閲覧を許可したユーザー
+)
+
+//TestMain はテスト用のライブラリとユーザーをメモリ上のDBに登録してからテストを実行する
+func TestMain(m *testing.M) {
+	tempDir, err := ioutil.TempDir("", "squidgirl-test")
+	if err != nil {
+		fmt.Printf("TestMain err=%s\n", err)
+		os.Exit(1)
+	}
+
+	err = setupTestLibrary(tempDir)
+	code := 1
+	if err == nil {
+		code = m.Run()
+	} else {
+		fmt.Printf("TestMain err=%s\n", err)
+	}
+	os.RemoveAll(tempDir)
+	os.Exit(code)
+}
+
+//setupTestLibrary は指定したフォルダにテスト用のライブラリを作成し、設定・DB・ユーザーを準備する
+func setupTestLibrary(tempDir string) error {
+	testLibraryDir = filepath.Join(tempDir, "library")
+	testOpenDir = filepath.Join(testLibraryDir, "open")
+	testPrivateDir = filepath.Join(testLibraryDir, "private")
+	testOpenBook = filepath.Join(testOpenDir, "book1.zip")
+	testTeenBook = filepath.Join(testOpenDir, "book2.zip")
+	testSecretBook = filepath.Join(testPrivateDir, "book3.zip")
+	for _, dir := range []string{testOpenDir, testPrivateDir, filepath.Join(tempDir, "cache"), filepath.Join(tempDir, "thumbnail")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := createTestArchive(testOpenBook, 3, ""); err != nil {
+		return err
+	}
+	if err := createTestArchive(testTeenBook, 2, "Teen"); err != nil {
+		return err
+	}
+	if err := createTestArchive(testSecretBook, 2, ""); err != nil {
+		return err
+	}
+
+	//テストに時間がかからないようにパスワードハッシュを軽くし、非同期の処理は行わない
+	testConfig := config.GetConfig()
+	testConfig.Login.Argon2Time = 1
+	testConfig.Login.Argon2MemoryKB = 1024
+	testConfig.Login.Argon2Threads = 1
+	testConfig.File.NotifyEnabled = false
+	testConfig.File.PreCacheImageCount = 0
+	testConfig.File.PageDirPath = filepath.Join(tempDir, "cache")
+	testConfig.File.ThumbnailDirPath = filepath.Join(tempDir, "thumbnail")
+	testConfig.Library = []config.LibraryConfig{{Name: testLibraryName, WatchDir: testLibraryDir, WatchInterval: 60, Direction: config.DirectionRightToLeft}}
+	config.SetConfig(testConfig)
+
+	db.SetStore(db.NewMemoryStore())
+	ctx := context.Background()
+	NewFileWatcher().RegistFile(ctx, "", true)
+
+	userList := []struct {
+		name       string
+		permission int
+	}{
+		{testAdminUser, db.UserPermissionAdmin},
+		{testUser, db.UserPermissionUser},
+		{testGuestUser, db.UserPermissionUser},
+		{testKidUser, db.UserPermissionUser},
+		{testOutUser, db.UserPermissionUser},
+	}
+	for _, user := range userList {
+		if err := db.InsertUser(ctx, user.name, testUserPassword, user.permission, false); err != nil {
+			return err
+		}
+	}
+	if err := db.InsertFolderGrant(ctx, db.CreateFolderHash(testOpenDir), testGuestUser, 0); err != nil {
+		return err
+	}
+	if err := db.InsertFolderGrant(ctx, db.CreateFolderHash(filepath.Join(tempDir, "outside")), testOutUser, 0); err != nil {
+		return err
+	}
+	return db.UpdateUserMaxAgeRating(ctx, testKidUser, 12)
+}
+
+//createTestArchive はPNG画像を指定したページ数だけ格納したZIPファイルを作成する（ageRatingの指定がある時はComicInfo.xmlも格納する）
+func createTestArchive(filePath string, pageCount int, ageRating string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	if ageRating != "" {
+		f, err := w.Create("ComicInfo.xml")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "<?xml version=\"1.0\"?>\n<ComicInfo><AgeRating>%s</AgeRating></ComicInfo>\n", ageRating)
+	}
+	for i := 0; i < pageCount; i++ {
+		f, err := w.Create(fmt.Sprintf("%03d.png", i+1))
+		if err != nil {
+			return err
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 16, 24))
+		for x := 0; x < 16; x++ {
+			img.Set(x, i, color.RGBA{R: 255, A: 255})
+		}
+		if err := png.Encode(f, img); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+//newTestContext はハンドラに渡すコンテキストとレスポンスの記録を生成する
+//formの指定がある時はフォームとして送信し、loginUserの指定がある時はログイン済みとしてコンテキストに保存する
+func newTestContext(method string, target string, form url.Values, loginUser *LoginUser) (echo.Context, *httptest.ResponseRecorder) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if loginUser != nil {
+		c.Set(loginUserContextKey, loginUser)
+	}
+	return c, rec
+}
+
+//newTestLoginUser は指定したユーザーのログイン情報をDBから生成する
+func newTestLoginUser(t *testing.T, userName string) *LoginUser {
+	user, err := db.SelectUser(context.Background(), userName)
+	if err != nil || user.ID == 0 {
+		t.Fatalf("SelectUser(%s) err=%v", userName, err)
+	}
+	return newLoginUserFromTable(user)
+}
+
+//testHash はテスト用ライブラリのフォルダ・アーカイブのハッシュを返す
+func testHash(t *testing.T, filePath string) string {
+	ctx := context.Background()
+	if strings.HasSuffix(filePath, ".zip") {
+		book, err := db.SelectBook(ctx, filePath)
+		if err != nil || book.Hash == "" {
+			t.Fatalf("SelectBook(%s) err=%v", filePath, err)
+		}
+		return book.Hash
+	}
+	return db.CreateFolderHash(filePath)
+}
+
+//responseCode はハンドラの戻り値とレスポンスの記録からステータスコードを返す
+func responseCode(err error, rec *httptest.ResponseRecorder) int {
+	if err == nil {
+		return rec.Code
+	}
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+const (
+	notifyCheckInterval = time.Second //変更通知を受けたファイルを確認する間隔
+)
+
+//NotifyWatcher はファイルシステムの変更通知を受け取り、変更があったファイル・フォルダだけを登録・更新・削除する
+type NotifyWatcher struct {
+	watcher     *fsnotify.Watcher
+	fileWatcher *FileWatcher
+	mutex       *sync.Mutex
+	pending     map[string]*notifyPending
+	delay       time.Duration
+}
+
+//notifyPending は変更通知を受けて処理待ちになっているパスの情報を保持する
+type notifyPending struct {
+	eventTime time.Time
+	size      int64
+	modTime   time.Time
+}
+
+//NewNotifyWatcher は変更通知の監視処理を生成する
+func NewNotifyWatcher(fileWatcher *FileWatcher) (*NotifyWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	notifyWatcher := new(NotifyWatcher)
+	notifyWatcher.watcher = watcher
+	notifyWatcher.fileWatcher = fileWatcher
+	notifyWatcher.mutex = new(sync.Mutex)
+	notifyWatcher.pending = make(map[string]*notifyPending)
+	notifyWatcher.delay = time.Duration(config.GetConfig().File.NotifyDelaySec) * time.Second
+	return notifyWatcher, nil
+}
+
+//Start は指定したフォルダ（複数指定可能）以下の監視をバックグラウンドで開始する
+func (notifyWatcher *NotifyWatcher) Start(baseDirList []string) error {
+	for _, baseDir := range baseDirList {
+		if err := notifyWatcher.addDirAll(baseDir); err != nil {
+			return err
+		}
+	}
+
+	go notifyWatcher.receiveEvent()
+	go notifyWatcher.processPending()
+	return nil
+}
+
+//Close は監視を終了する
+func (notifyWatcher *NotifyWatcher) Close() error {
+	return notifyWatcher.watcher.Close()
+}
+
+//addDirAll は指定したフォルダ以下のすべてのフォルダを監視対象に追加する（変更通知はサブフォルダを含まないため）
+func (notifyWatcher *NotifyWatcher) addDirAll(dirPath string) error {
+	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if err := notifyWatcher.watcher.Add(path); err != nil {
+			fmt.Printf("NotifyWatcher.addDirAll err=%s path=%s\n", err, path)
+			return err
+		}
+		return nil
+	})
+}
+
+//receiveEvent は変更通知を受け取って処理待ちに追加する
+func (notifyWatcher *NotifyWatcher) receiveEvent() {
+	for {
+		select {
+		case event, ok := <-notifyWatcher.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			fmt.Printf("NotifyWatcher event=%s\n", event)
+
+			//新しく作られたフォルダは監視対象に追加する
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				info, err := os.Stat(event.Name)
+				if err == nil && info.IsDir() {
+					notifyWatcher.addDirAll(event.Name)
+				}
+			}
+			notifyWatcher.addPending(event.Name)
+		case err, ok := <-notifyWatcher.watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Printf("NotifyWatcher err=%s\n", err)
+		}
+	}
+}
+
+//addPending は指定したパスを処理待ちに追加する（すでにある時は待ち時間を延長する）
+func (notifyWatcher *NotifyWatcher) addPending(path string) {
+	notifyWatcher.mutex.Lock()
+	defer notifyWatcher.mutex.Unlock()
+
+	pending, ok := notifyWatcher.pending[path]
+	if !ok {
+		pending = new(notifyPending)
+		notifyWatcher.pending[path] = pending
+	}
+	pending.eventTime = time.Now()
+}
+
+//processPending は一定時間変更がなくなったパスを定期的に登録・削除する
+func (notifyWatcher *NotifyWatcher) processPending() {
+	ticker := time.NewTicker(notifyCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		existList, removeList := notifyWatcher.popReadyPath()
+		if len(existList) == 0 && len(removeList) == 0 {
+			continue
+		}
+
+		//移動・名前変更を検出できるよう、削除より先に登録を行う
+		ctx := context.Background()
+		for _, path := range existList {
+			notifyWatcher.fileWatcher.RegistPath(ctx, path)
+		}
+		for _, path := range removeList {
+			notifyWatcher.fileWatcher.RemovePath(ctx, path)
+		}
+	}
+}
+
+//popReadyPath は処理待ちのパスのうち一定時間変更がなくなったものを取り出して、存在するパスと削除されたパスに分けて返す
+//コピー中のファイルを登録しないよう、サイズと更新日時が前回確認時から変わっていない時だけ取り出す
+func (notifyWatcher *NotifyWatcher) popReadyPath() ([]string, []string) {
+	notifyWatcher.mutex.Lock()
+	defer notifyWatcher.mutex.Unlock()
+
+	now := time.Now()
+	existList := make([]string, 0)
+	removeList := make([]string, 0)
+	for path, pending := range notifyWatcher.pending {
+		if now.Sub(pending.eventTime) < notifyWatcher.delay {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			removeList = append(removeList, path)
+			delete(notifyWatcher.pending, path)
+			continue
+		} else if err != nil {
+			delete(notifyWatcher.pending, path)
+			continue
+		}
+
+		if !info.IsDir() && (pending.size != info.Size() || !pending.modTime.Equal(info.ModTime())) {
+			//まだ書き込み中の可能性があるので次回確認する
+			pending.size = info.Size()
+			pending.modTime = info.ModTime()
+			pending.eventTime = now
+			continue
+		}
+		existList = append(existList, path)
+		delete(notifyWatcher.pending, path)
+	}
+
+	//親フォルダから順番に処理する
+	sort.Strings(existList)
+	sort.Strings(removeList)
+	return existList, removeList
+}
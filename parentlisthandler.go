@@ -6,12 +6,14 @@ import (
 	"path/filepath"
 
 	"github.com/labstack/echo"
+	"github.com/mryp/squidgirl-go/config"
 	"github.com/mryp/squidgirl-go/db"
 )
 
 //ParentListRequest はファイル一覧情報取得のリクエストデータを保持する
 type ParentListRequest struct {
-	Hash string `json:"hash" xml:"hash" form:"hash" query:"hash"`
+	Library string `json:"library" xml:"library" form:"library" query:"library"`
+	Hash    string `json:"hash" xml:"hash" form:"hash" query:"hash"`
 }
 
 //ParentListResponce はファイル一覧情報取得のレスポンスデータを保持する
@@ -37,37 +39,40 @@ func ParentListHandler(c echo.Context) error {
 	//フォルダ情報レスポンスを作成
 	folders := make([]ParentListFolderResponce, 0)
 
-	//ルートを取得
-	rootFolder, err := db.SelectFolderRoot()
+	//ライブラリのルートと現在のフォルダーを取得（フォルダ指定なしの時はルート）
+	library, ok := findLibraryFromName(req.Library)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "指定したライブラリが見つからない")
+	}
+	ctx := c.Request().Context()
+	rootFolder, selectFolder, err := selectLibraryFolder(ctx, library, req.Hash)
 	if err != nil {
 		return err
 	}
-
-	selectHash := req.Hash
-	if selectHash == "" {
-		//フォルダハッシュをルートとして取得
-		selectHash = rootFolder.Hash
-	}
-
-	//現在のフォルダーを取得
-	selectFolder, err := db.SelectFolderFromHash(selectHash)
+	access, err := newFolderAccess(ctx, NewLoginUserFromRequest(c))
 	if err != nil {
 		return err
 	}
-	folders = append(folders, createFolderItemFromFolder(selectFolder, rootFolder))
+	if !access.CanBrowse(selectFolder.FilePath) {
+		return echo.NewHTTPError(http.StatusForbidden, "閲覧が許可されていない")
+	}
+	folders = append(folders, createFolderItemFromFolder(selectFolder, rootFolder, library))
 
-	//親フォルダをさかのぼって追加
+	//親フォルダをライブラリのルートまでさかのぼって追加
 	parentHash := selectFolder.ParentHash
 	for {
-		if parentHash == "" {
+		if parentHash == "" || selectFolder.Hash == rootFolder.Hash {
 			break
 		}
 
-		parentFolder, err := db.SelectFolderFromHash(parentHash)
+		parentFolder, err := db.SelectFolderFromHash(ctx, parentHash)
 		if err != nil {
 			break
 		}
-		folders = append(folders, createFolderItemFromFolder(parentFolder, rootFolder))
+		folders = append(folders, createFolderItemFromFolder(parentFolder, rootFolder, library))
+		if parentFolder.Hash == rootFolder.Hash {
+			break
+		}
 		parentHash = parentFolder.ParentHash
 	}
 
@@ -78,10 +83,10 @@ func ParentListHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, responce)
 }
 
-func createFolderItemFromFolder(folder db.FolderTable, rootFolder db.FolderTable) ParentListFolderResponce {
+func createFolderItemFromFolder(folder db.FolderTable, rootFolder db.FolderTable, library config.LibraryConfig) ParentListFolderResponce {
 	name := filepath.Base(folder.FilePath)
 	if rootFolder.Hash == folder.Hash {
-		name = "ルートフォルダ"
+		name = library.Name
 	}
 	return ParentListFolderResponce{
 		Hash: folder.Hash,
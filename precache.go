@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+const (
+	preCacheHistoryCount  = 8                //読み込み方向と速度の判定に使用する直近のページ要求数
+	preCacheHistoryExpire = 5 * time.Minute  //これ以上間隔が空いたページ要求は履歴として扱わない
+	preCacheStateExpire   = 30 * time.Minute //これ以上アクセスがない読書状態は破棄する
+	preCacheJumpPageCount = 10               //これ以上離れたページへの移動はジャンプとして扱う
+)
+
+var (
+	preCacher     *PreCacher
+	preCacherOnce sync.Once
+)
+
+//PreCacher はユーザーごとのページ要求履歴から読み込み方向と速度を推定して先読み展開を行う
+type PreCacher struct {
+	mutex         *sync.Mutex
+	states        map[string]*readingState
+	baseCount     int
+	maxCount      int
+	lookAheadTime time.Duration
+}
+
+//readingState はユーザー・書庫ごとの読書状態を保持する
+type readingState struct {
+	requests   []pageRequest
+	warmedHash string
+	accessTime time.Time
+}
+
+//pageRequest はページ要求1回分の情報を保持する
+type pageRequest struct {
+	index int
+	time  time.Time
+}
+
+//preCacheRange は先読み展開を行う範囲を保持する
+type preCacheRange struct {
+	start int
+	limit int
+}
+
+//NewPreCacher は先読み処理にデフォルト値をセットして返す
+//なお、インスタンスは1つしか生成せず、既に存在する場合はそれを返す
+func NewPreCacher() *PreCacher {
+	preCacherOnce.Do(func() {
+		fileConfig := config.GetConfig().File
+		cacher := new(PreCacher)
+		cacher.mutex = new(sync.Mutex)
+		cacher.states = make(map[string]*readingState)
+		cacher.baseCount = fileConfig.PreCacheImageCount
+		cacher.maxCount = fileConfig.PreCacheMaxImageCount
+		if cacher.maxCount < cacher.baseCount {
+			cacher.maxCount = cacher.baseCount
+		}
+		cacher.lookAheadTime = time.Duration(fileConfig.PreCacheLookAheadSec) * time.Second
+		preCacher = cacher
+	})
+	return preCacher
+}
+
+//Request はページ要求を記録し、推定した読み込み方向と速度に合わせて先読み展開を行う（非同期）
+func (cacher *PreCacher) Request(ctx context.Context, userName string, bookPage *BookPage, index int, maxHeight uint, maxWidth uint) {
+	if cacher.baseCount <= 0 {
+		return
+	}
+
+	book, err := db.SelectBookFromHash(ctx, bookPage.Hash)
+	if err != nil || book.Hash == "" {
+		return
+	}
+
+	rangeList, warmNext := cacher.record(userName, book, index)
+	go func() {
+		for _, r := range rangeList {
+			if r.limit <= 0 {
+				continue
+			}
+			bookPage.UnzipPageFileMutex(r.start, r.limit, maxHeight, maxWidth)
+		}
+		if warmNext {
+			//リクエスト終了後も処理を続けるためリクエストのコンテキストは使用しない
+			cacher.warmNextBook(context.Background(), book, maxHeight, maxWidth)
+		}
+	}()
+}
+
+//record はページ要求を履歴に追加し、先読み範囲と次の書庫を準備するかどうかを返す
+func (cacher *PreCacher) record(userName string, book db.BookTable, index int) ([]preCacheRange, bool) {
+	cacher.mutex.Lock()
+	defer cacher.mutex.Unlock()
+
+	now := time.Now()
+	cacher.clearOldState(now)
+
+	key := userName + "_" + book.Hash
+	state, ok := cacher.states[key]
+	if !ok {
+		state = new(readingState)
+		cacher.states[key] = state
+	}
+	state.accessTime = now
+	state.add(pageRequest{index: index, time: now})
+
+	rangeList := make([]preCacheRange, 0)
+	direction, pace, jumped := state.estimate()
+	window := cacher.getWindowCount(pace)
+	if direction > 0 {
+		rangeList = append(rangeList, newPreCacheRange(index+1, index+window, book.Page))
+	} else {
+		rangeList = append(rangeList, newPreCacheRange(index-window, index-1, book.Page))
+	}
+	if jumped {
+		//ジャンプ直後はどちらに読み進めるかわからないので前のページも少しだけ準備する
+		rangeList = append(rangeList, newPreCacheRange(index-cacher.baseCount, index-1, book.Page))
+	}
+
+	//読み終わりに近づいたら次の書庫の先頭ページを準備する
+	warmNext := false
+	if direction > 0 && book.Page > 0 && index+window >= book.Page-1 && state.warmedHash != book.Hash {
+		state.warmedHash = book.Hash
+		warmNext = true
+	}
+	return rangeList, warmNext
+}
+
+//getWindowCount は読み込み速度（ページ/秒）から先読みするページ数を返す
+func (cacher *PreCacher) getWindowCount(pace float64) int {
+	window := int(math.Ceil(pace * cacher.lookAheadTime.Seconds()))
+	if window < cacher.baseCount {
+		window = cacher.baseCount
+	}
+	if window > cacher.maxCount {
+		window = cacher.maxCount
+	}
+	return window
+}
+
+//clearOldState は一定時間アクセスのない読書状態を破棄する
+func (cacher *PreCacher) clearOldState(now time.Time) {
+	for key, state := range cacher.states {
+		if now.Sub(state.accessTime) > preCacheStateExpire {
+			delete(cacher.states, key)
+		}
+	}
+}
+
+//warmNextBook は同じフォルダ内の次の書庫の先頭ページを展開する
+func (cacher *PreCacher) warmNextBook(ctx context.Context, book db.BookTable, maxHeight uint, maxWidth uint) {
+	bookList, err := db.SelectBookListFromFolder(ctx, book.FolderHash)
+	if err != nil {
+		return
+	}
+	bookList = db.ExcludeDeletedBook(bookList)
+	sort.Slice(bookList, func(i, j int) bool {
+		return filepath.Base(bookList[i].FilePath) < filepath.Base(bookList[j].FilePath)
+	})
+
+	for i, v := range bookList {
+		if v.Hash != book.Hash || i+1 >= len(bookList) {
+			continue
+		}
+
+		next := bookList[i+1]
+		fmt.Printf("PreCacher.warmNextBook next=%s\n", next.FilePath)
+		NewBookPage(ctx, next.Hash, next.FilePath).UnzipPageFileMutex(0, cacher.baseCount, maxHeight, maxWidth)
+		break
+	}
+}
+
+//add はページ要求を履歴に追加する（古い要求と上限を超えた要求は捨てる）
+func (state *readingState) add(req pageRequest) {
+	if len(state.requests) > 0 {
+		last := state.requests[len(state.requests)-1]
+		if req.time.Sub(last.time) > preCacheHistoryExpire {
+			state.requests = state.requests[:0]
+		}
+	}
+
+	state.requests = append(state.requests, req)
+	if len(state.requests) > preCacheHistoryCount {
+		state.requests = state.requests[len(state.requests)-preCacheHistoryCount:]
+	}
+}
+
+//estimate は履歴から読み込み方向（1=順方向, -1=逆方向）と速度（ページ/秒）、直前にジャンプしたかどうかを返す
+func (state *readingState) estimate() (int, float64, bool) {
+	count := len(state.requests)
+	if count < 2 {
+		return 1, 0, false
+	}
+
+	//直前の移動がジャンプだった時は履歴をジャンプ先から取り直す
+	last := state.requests[count-1]
+	prev := state.requests[count-2]
+	if absInt(last.index-prev.index) >= preCacheJumpPageCount {
+		state.requests = []pageRequest{last}
+		return 1, 0, true
+	}
+
+	forward := 0
+	backward := 0
+	pages := 0
+	for i := 1; i < count; i++ {
+		diff := state.requests[i].index - state.requests[i-1].index
+		if diff > 0 {
+			forward++
+		} else if diff < 0 {
+			backward++
+		}
+		pages += absInt(diff)
+	}
+
+	direction := 1
+	if backward > forward {
+		direction = -1
+	}
+	pace := 0.0
+	seconds := last.time.Sub(state.requests[0].time).Seconds()
+	if seconds > 0 {
+		pace = float64(pages) / seconds
+	}
+	return direction, pace, false
+}
+
+//newPreCacheRange は開始・終了ページ位置から書庫のページ範囲に収まる先読み範囲を生成する
+func newPreCacheRange(first int, last int, pageCount int) preCacheRange {
+	if first < 0 {
+		first = 0
+	}
+	if pageCount > 0 && last > pageCount-1 {
+		last = pageCount - 1
+	}
+	limit := last - first + 1
+	if limit < 0 {
+		limit = 0
+	}
+	return preCacheRange{start: first, limit: limit}
+}
+
+//absInt は整数の絶対値を返す
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"image"
+	_ "image/gif" //image.Decode でGIFファイルを読み込むのに必要
 	"image/jpeg"
 	_ "image/png" //image.Decode でPNGファイルを読み込むのに必要
 	"io"
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+const (
+	ignoreFileName = ".squidignore" //フォルダごとの除外設定ファイル名
+)
+
+//defaultExcludeList は設定に関係なく登録しないファイル・フォルダ（OSやNASが作成するもの）
+var defaultExcludeList = []string{".git", "@eaDir", "#recycle", "$RECYCLE.BIN", "System Volume Information", ".Trash*", "._*", ".DS_Store", "Thumbs.db"}
+
+//scanFilter は探索対象にするファイル・フォルダの条件を保持する
+type scanFilter struct {
+	includeList []filterPattern //指定がある時はいずれかに一致するファイルだけを対象とする
+	excludeList []filterPattern //いずれかに一致するファイル・フォルダは対象外とする
+}
+
+//filterPattern はglob形式の条件1件分を保持する
+type filterPattern struct {
+	baseDir  string //相対パスで比較する時の基準フォルダ
+	pattern  string
+	dirOnly  bool //末尾が"/"の時はフォルダだけに一致させる
+	anchored bool //先頭が"/"の時は基準フォルダからの相対パスだけと比較する
+}
+
+//newScanFilter はライブラリ設定から探索条件を生成する
+func newScanFilter(library config.LibraryConfig) *scanFilter {
+	filter := new(scanFilter)
+	filter.includeList = createFilterPatternList(library.WatchDir, library.Include)
+	filter.excludeList = createFilterPatternList(library.WatchDir, defaultExcludeList)
+	filter.excludeList = append(filter.excludeList, createFilterPatternList(library.WatchDir, config.GetConfig().File.Exclude)...)
+	filter.excludeList = append(filter.excludeList, createFilterPatternList(library.WatchDir, library.Exclude)...)
+	return filter
+}
+
+//newScanFilterFromPath はライブラリのルートから指定したフォルダ（指定したフォルダを含む）までの除外設定ファイルを反映した探索条件を生成する
+func newScanFilterFromPath(library config.LibraryConfig, dirPath string) *scanFilter {
+	filter := newScanFilter(library)
+	rel, err := filepath.Rel(library.WatchDir, dirPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filter
+	}
+
+	currentPath := library.WatchDir
+	filter = filter.withIgnoreFile(currentPath)
+	if rel == "." {
+		return filter
+	}
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		currentPath = filepath.Join(currentPath, name)
+		filter = filter.withIgnoreFile(currentPath)
+	}
+	return filter
+}
+
+//withIgnoreFile は指定したフォルダに除外設定ファイルがある時は、その内容を追加した探索条件を返す
+//除外設定ファイルの条件は、そのフォルダ以下のファイル・フォルダに適用する
+func (filter *scanFilter) withIgnoreFile(dirPath string) *scanFilter {
+	patternList, err := loadIgnoreFile(filepath.Join(dirPath, ignoreFileName))
+	if err != nil || len(patternList) == 0 {
+		return filter
+	}
+
+	child := new(scanFilter)
+	child.includeList = filter.includeList
+	child.excludeList = make([]filterPattern, 0, len(filter.excludeList)+len(patternList))
+	child.excludeList = append(child.excludeList, filter.excludeList...)
+	child.excludeList = append(child.excludeList, createFilterPatternList(dirPath, patternList)...)
+	return child
+}
+
+//filterEntryList はフォルダ内の項目一覧から探索対象のものだけを返す
+func (filter *scanFilter) filterEntryList(dirPath string, entryList []dirEntry) []dirEntry {
+	resultList := make([]dirEntry, 0, len(entryList))
+	for _, entry := range entryList {
+		if filter.isTarget(filepath.Join(dirPath, entry.name), entry.isDir) {
+			resultList = append(resultList, entry)
+		}
+	}
+	return resultList
+}
+
+//isTarget は指定したファイル・フォルダが探索対象かどうかを返す
+func (filter *scanFilter) isTarget(path string, isDir bool) bool {
+	for _, pattern := range filter.excludeList {
+		if pattern.match(path, isDir) {
+			return false
+		}
+	}
+	if isDir || len(filter.includeList) == 0 {
+		return true
+	}
+	for _, pattern := range filter.includeList {
+		if pattern.match(path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+//match は指定したパスが条件に一致するかどうかを返す
+//条件はファイル名、または基準フォルダからの相対パス（区切り文字は"/"）と比較する
+func (pattern filterPattern) match(filePath string, isDir bool) bool {
+	if pattern.dirOnly && !isDir {
+		return false
+	}
+	if !pattern.anchored {
+		if ok, _ := path.Match(pattern.pattern, filepath.Base(filePath)); ok {
+			return true
+		}
+	}
+	rel, err := filepath.Rel(pattern.baseDir, filePath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	ok, _ := path.Match(pattern.pattern, filepath.ToSlash(rel))
+	return ok
+}
+
+//createFilterPatternList はglob形式の文字列リストから条件リストを生成する
+func createFilterPatternList(baseDir string, textList []string) []filterPattern {
+	patternList := make([]filterPattern, 0, len(textList))
+	for _, text := range textList {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		pattern := filterPattern{baseDir: baseDir, pattern: text}
+		if strings.HasPrefix(pattern.pattern, "/") {
+			pattern.pattern = strings.TrimPrefix(pattern.pattern, "/")
+			pattern.anchored = true
+		}
+		if strings.HasSuffix(pattern.pattern, "/") {
+			pattern.pattern = strings.TrimSuffix(pattern.pattern, "/")
+			pattern.dirOnly = true
+		}
+		patternList = append(patternList, pattern)
+	}
+	return patternList
+}
+
+//loadIgnoreFile は除外設定ファイルを読み込む（1行1条件、#で始まる行はコメント）
+func loadIgnoreFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	textList := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		textList = append(textList, line)
+	}
+	return textList, scanner.Err()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mryp/squidgirl-go/config"
+)
+
+func TestScanFilterDefaultExclude(t *testing.T) {
+	//設定で除外条件を指定してもデフォルトの除外条件は適用する
+	baseConfig := config.GetConfig()
+	testConfig := baseConfig
+	testConfig.File.Exclude = []string{"*_raw.zip"}
+	config.SetConfig(testConfig)
+	defer config.SetConfig(baseConfig)
+
+	library := config.LibraryConfig{Name: testLibraryName, WatchDir: testLibraryDir, Exclude: []string{"/work/"}}
+	filter := newScanFilter(library)
+	pathMap := map[string]bool{
+		filepath.Join(testLibraryDir, "@eaDir"):            false,
+		filepath.Join(testLibraryDir, "open", "._a.zip"):   false,
+		filepath.Join(testLibraryDir, "open", "a_raw.zip"): false,
+		filepath.Join(testLibraryDir, "work"):              false,
+		filepath.Join(testLibraryDir, "open", "a.zip"):     true,
+		filepath.Join(testLibraryDir, "open", "work"):      true,
+	}
+	for path, expected := range pathMap {
+		isDir := filepath.Ext(path) == ""
+		if filter.isTarget(path, isDir) != expected {
+			t.Errorf("%s isTarget=%v", path, !expected)
+		}
+	}
+}
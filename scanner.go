@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//scanResult はアーカイブ1件分の確認結果
+type scanResult int
+
+const (
+	scanResultNone     scanResult = iota //変更なし
+	scanResultAdded                      //新規登録
+	scanResultUpdated                    //ファイル内容の更新
+	scanResultMoved                      //移動・名前変更
+	scanResultRestored                   //削除済みから復元
+)
+
+var (
+	bookMoveMutex = new(sync.Mutex) //移動されたアーカイブの確認と引き継ぎを排他する
+)
+
+//libraryScan は1回分のライブラリ探索処理の状態を保持する
+//フォルダの探索は呼び出し元のゴルーチンで行い、アーカイブの確認とサムネイル作成はワーカーで並列に行う
+type libraryScan struct {
+	ctx          context.Context
+	full         bool                        //trueの時は更新日時が変わっていないフォルダも確認する
+	folderMap    map[string]db.FolderTable   //ファイルパスをキーにした登録済みフォルダ情報
+	childMap     map[string][]db.FolderTable //親フォルダのハッシュをキーにした登録済みフォルダ情報
+	existPathMap map[string]bool             //探索で存在を確認したファイル・フォルダのパス
+	movedMap     map[string]bool             //移動先で引き継いだアーカイブのハッシュ（bookMoveMutexでロックして使用する）
+	failed       bool                        //探索・反映できなかったフォルダがあった時はtrue（setFailedで設定する）
+	failedMutex  *sync.Mutex
+	pool         *workerPool
+	wg           *sync.WaitGroup //フォルダ単位の登録処理の待ち合わせ
+	progress     *ScanProgress
+}
+
+//folderTask はフォルダ1件分のアーカイブの確認結果を保持する
+type folderTask struct {
+	mutex      *sync.Mutex
+	wg         *sync.WaitGroup
+	insertList []db.BookTable
+	updateList []db.BookTable
+}
+
+//dirEntry はフォルダ内の項目1件分の情報を保持する
+type dirEntry struct {
+	name  string
+	isDir bool
+}
+
+//newLibraryScan はライブラリ探索処理を生成する（登録済みフォルダ情報はまとめて読み込む）
+//探索後は必ずwaitを呼び出すこと
+func newLibraryScan(ctx context.Context, full bool, progress *ScanProgress) (*libraryScan, error) {
+	folderList, err := db.SelectFolderAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := new(libraryScan)
+	scan.ctx = ctx
+	scan.full = full
+	scan.folderMap = make(map[string]db.FolderTable)
+	scan.childMap = make(map[string][]db.FolderTable)
+	for _, folder := range folderList {
+		scan.folderMap[folder.FilePath] = folder
+		scan.childMap[folder.ParentHash] = append(scan.childMap[folder.ParentHash], folder)
+	}
+	scan.existPathMap = make(map[string]bool)
+	scan.movedMap = make(map[string]bool)
+	scan.failedMutex = new(sync.Mutex)
+	scan.pool = newWorkerPool(config.GetConfig().File.ScanWorkerCount)
+	scan.wg = new(sync.WaitGroup)
+	scan.progress = progress
+	return scan, nil
+}
+
+//scanDir は指定したフォルダ以下を探索して登録・更新する
+//filterには指定したフォルダの除外設定ファイルまで反映した探索条件を指定する
+//フォルダの更新日時が前回から変わっていない時は、フォルダの読み込みとアーカイブの確認を省略してサブフォルダだけを探索する
+//（ファイルの上書き更新はフォルダの更新日時が変わらないため、変更通知または完全探索で反映する）
+func (scan *libraryScan) scanDir(dirPath string, parentHash string, filter *scanFilter) {
+	if scan.ctx.Err() != nil {
+		//中止された
+		scan.setFailed()
+		return
+	}
+	info, err := os.Stat(dirPath)
+	if err != nil || !info.IsDir() {
+		fmt.Printf("libraryScan.scanDir stat err=%v path=%s\n", err, dirPath)
+		scan.setFailed()
+		return
+	}
+	scan.existPathMap[dirPath] = true
+
+	folder, ok := scan.folderMap[dirPath]
+	if !ok {
+		//途中で中断しても次回探索し直すよう、更新日時は探索完了後に設定する
+		err := db.InsertFolder(scan.ctx, dirPath, parentHash, unknownTime)
+		if err != nil {
+			scan.setFailed()
+			return
+		}
+		folder = db.FolderTable{Hash: db.CreateFolderHash(dirPath), ParentHash: parentHash, FilePath: dirPath, ModTime: unknownTime}
+		scan.folderMap[dirPath] = folder
+	}
+
+	entryList, unchanged, err := scan.readDir(dirPath, folder, info)
+	if err != nil {
+		fmt.Printf("libraryScan.scanDir readDir err=%s path=%s\n", err, dirPath)
+		scan.setFailed()
+		return
+	}
+	entryList = filter.filterEntryList(dirPath, entryList)
+	if unchanged {
+		seenCount := 0
+		for _, entry := range entryList {
+			scan.existPathMap[filepath.Join(dirPath, entry.name)] = true
+			if !entry.isDir {
+				seenCount++
+			}
+		}
+		scan.progress.addSeen(seenCount, 0)
+	} else {
+		scan.registBookList(dirPath, folder, entryList, info.ModTime())
+	}
+
+	for _, entry := range entryList {
+		if entry.isDir {
+			childPath := filepath.Join(dirPath, entry.name)
+			scan.scanDir(childPath, folder.Hash, filter.withIgnoreFile(childPath))
+		}
+	}
+}
+
+//readDir はフォルダ内の項目一覧と、前回探索時から変更がないかどうかを返す
+//変更がない時はフォルダを読み込まず、登録済みの情報から項目一覧を作成する
+func (scan *libraryScan) readDir(dirPath string, folder db.FolderTable, info os.FileInfo) ([]dirEntry, bool, error) {
+	if !scan.full && folder.ModTime.Unix() == info.ModTime().Unix() {
+		bookList, err := db.SelectBookListFromFolder(scan.ctx, folder.Hash)
+		if err != nil {
+			return nil, false, err
+		}
+		bookList = db.ExcludeDeletedBook(bookList)
+		entryList := make([]dirEntry, 0, len(bookList))
+		for _, book := range bookList {
+			entryList = append(entryList, dirEntry{name: filepath.Base(book.FilePath), isDir: false})
+		}
+		for _, child := range scan.childMap[folder.Hash] {
+			entryList = append(entryList, dirEntry{name: filepath.Base(child.FilePath), isDir: true})
+		}
+		return entryList, true, nil
+	}
+
+	osEntryList, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, false, err
+	}
+	entryList := make([]dirEntry, 0, len(osEntryList))
+	for _, osEntry := range osEntryList {
+		entryList = append(entryList, dirEntry{name: osEntry.Name(), isDir: osEntry.IsDir()})
+	}
+	return entryList, false, nil
+}
+
+//registBookList はフォルダ内のアーカイブの確認をワーカーに追加する
+//フォルダ内のアーカイブの確認がすべて終わった後に、確認結果とフォルダの更新日時をまとめて反映する
+func (scan *libraryScan) registBookList(dirPath string, folder db.FolderTable, entryList []dirEntry, modTime time.Time) {
+	//登録済みのアーカイブ情報はフォルダ単位でまとめて読み込む
+	bookList, err := db.SelectBookListFromFolder(scan.ctx, folder.Hash)
+	if err != nil {
+		scan.setFailed()
+		return
+	}
+	bookMap := make(map[string]db.BookTable)
+	for _, book := range bookList {
+		bookMap[book.FilePath] = book
+	}
+
+	task := new(folderTask)
+	task.mutex = new(sync.Mutex)
+	task.wg = new(sync.WaitGroup)
+	seenCount := 0
+	for _, entry := range entryList {
+		path := filepath.Join(dirPath, entry.name)
+		if entry.isDir || !isTargetFile(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		scan.existPathMap[path] = true
+		seenCount++
+
+		book := bookMap[path]
+		task.wg.Add(1)
+		scan.pool.Submit(func() {
+			defer task.wg.Done()
+			if scan.ctx.Err() != nil {
+				return
+			}
+			if book.Hash == "" {
+				//フォルダ移動の時は別のフォルダに登録されているので、パスでも確認する
+				book, _ = db.SelectBook(scan.ctx, path)
+			}
+			record, result, err := scan.registBookInfo(book, folder.Hash, path, info)
+			scan.progress.addResult(result, err)
+			task.mutex.Lock()
+			if result == scanResultAdded {
+				task.insertList = append(task.insertList, record)
+			} else if record != book {
+				task.updateList = append(task.updateList, record)
+			}
+			task.mutex.Unlock()
+		})
+	}
+	scan.progress.addSeen(seenCount, seenCount)
+
+	scan.wg.Add(1)
+	go func() {
+		defer scan.wg.Done()
+		task.wg.Wait()
+		scan.finishFolder(folder, task, modTime)
+	}()
+}
+
+//finishFolder はフォルダ内のアーカイブの確認が終わった後に、確認結果とフォルダの更新日時を1つのトランザクションで反映する
+//途中で中止・失敗した時はフォルダの更新日時を設定しないため、次回探索し直す
+func (scan *libraryScan) finishFolder(folder db.FolderTable, task *folderTask, modTime time.Time) {
+	if scan.ctx.Err() != nil {
+		//中止された時は確認していないアーカイブがあるため、何も反映しない
+		return
+	}
+	result := db.FolderScanResult{Folder: folder, InsertList: task.insertList, UpdateList: task.updateList}
+	result.Folder.ModTime = modTime
+	if err := db.ApplyFolderScanResult(scan.ctx, result); err != nil {
+		scan.setFailed()
+		return
+	}
+	scan.progress.addAdded(len(task.insertList))
+	thum := NewThumbnail()
+	for _, record := range task.insertList {
+		if record.Status == db.BookStatusBroken {
+			continue
+		}
+		record := record
+		scan.pool.Submit(func() {
+			thum.CreateFile(record.Hash, record.FilePath)
+		})
+	}
+}
+
+//setFailed は探索・反映できなかったフォルダがあったことを設定する
+func (scan *libraryScan) setFailed() {
+	scan.failedMutex.Lock()
+	defer scan.failedMutex.Unlock()
+
+	scan.failed = true
+}
+
+//wait は追加したすべての確認処理が終わるまで待ってワーカーを終了する
+func (scan *libraryScan) wait() {
+	scan.wg.Wait()
+	scan.pool.Wait()
+	scan.pool.Close()
+}
+
+//registBookInfo はアーカイブ情報を確認し、新規・更新・移動を反映したアーカイブ情報を返す
+//DBへの反映はフォルダ単位でまとめて行うため、ここでは登録・更新しない（変更がない時は引数のアーカイブ情報をそのまま返す）
+func (scan *libraryScan) registBookInfo(book db.BookTable, folderHash string, path string, info os.FileInfo) (db.BookTable, scanResult, error) {
+	thum := NewThumbnail()
+	if book.Hash == "" {
+		fingerprint, err := CreateFileFingerprint(path)
+		if err != nil {
+			fmt.Printf("registBookInfo fingerprint err=%s\n", err)
+		}
+
+		//移動・名前変更されたアーカイブは既存の情報を引き継ぐ
+		//同じ内容のファイルが並列で同じアーカイブを引き継がないよう、確認と引き継ぎはまとめて行う
+		bookMoveMutex.Lock()
+		movedBook := scan.findMovedBook(fingerprint)
+		if movedBook.Hash != "" {
+			scan.movedMap[movedBook.Hash] = true
+		}
+		bookMoveMutex.Unlock()
+		if movedBook.Hash != "" {
+			if !thum.IsExist(thum.GetFilePathFromHash(movedBook.Hash)) {
+				thum.CreateFile(movedBook.Hash, path)
+			}
+			movedBook = db.RestoreBook(movedBook)
+			movedBook.FolderHash = folderHash
+			movedBook.FilePath = path
+			return movedBook, scanResultMoved, nil
+		}
+
+		//新規登録（壊れているアーカイブも確認結果を付けて登録する）
+		archive, err := validateArchive(path)
+		record := db.NewBookRecord(folderHash, path, fingerprint, int(info.Size()), archive.Page, info.ModTime())
+		record.Status, record.ErrorMessage = createBookStatus(err)
+		record.CoverHash = archive.CoverHash
+		record.AgeRating = archive.AgeRating
+		return record, scanResultAdded, err
+	}
+
+	record := book
+	result := scanResultNone
+	if record.Deleted {
+		//見つからなくなっていたアーカイブが再び見つかった（履歴などはそのまま引き継ぐ）
+		record = db.RestoreBook(record)
+		result = scanResultRestored
+	}
+	if record.FolderHash != folderHash {
+		//フォルダごと移動された
+		record.FolderHash = folderHash
+		record.FilePath = path
+		result = scanResultMoved
+	}
+	if !isEquleDateTime(record.ModTime, info.ModTime()) {
+		//更新あり
+		fingerprint, _ := CreateFileFingerprint(path)
+		archive, err := validateArchive(path)
+		if err == nil {
+			thum.CreateFile(record.Hash, path)
+		}
+		removePageCache(record.Hash)
+		record.Fingerprint = fingerprint
+		record.FileSize = int(info.Size())
+		record.Page = archive.Page
+		record.ModTime = info.ModTime()
+		record.Status, record.ErrorMessage = createBookStatus(err)
+		record.CoverHash = archive.CoverHash
+		record.AgeRating = archive.AgeRating
+		return record, scanResultUpdated, err
+	}
+
+	if record.Fingerprint == "" {
+		//識別値がない登録済みアーカイブは識別値だけ追加する
+		fingerprint, err := CreateFileFingerprint(path)
+		if err == nil {
+			record.Fingerprint = fingerprint
+		}
+	}
+	var err error
+	if record.Status == db.BookStatusUnchecked || (record.Status != db.BookStatusBroken && (record.CoverHash == "" || record.AgeRating == db.AgeRatingUnread)) ||
+		(scan.full && record.Status == db.BookStatusBroken) {
+		//確認処理・知覚ハッシュ・対象年齢追加前に登録されたアーカイブは確認結果だけ追加する
+		//すべて確認する時は壊れていると判定したアーカイブも確認し直す（画像以外のファイルを先頭ページとしていた時の判定を直す）
+		//画像以外のファイルもページとしていた時のページ位置でキャッシュしたページ画像は削除し、ページ数も数え直す
+		var archive archiveInfo
+		archive, err = validateArchive(path)
+		removePageCache(record.Hash)
+		record.Page = archive.Page
+		record.CoverHash = archive.CoverHash
+		record.AgeRating = archive.AgeRating
+		record.Status, record.ErrorMessage = createBookStatus(err)
+	}
+	if record.Status != db.BookStatusBroken && !thum.IsExist(thum.GetFilePathFromHash(record.Hash)) {
+		thum.CreateFile(record.Hash, path)
+	}
+	return record, result, err
+}
+
+//findMovedBook は同じファイル内容で元のファイルが存在しなくなったアーカイブ情報を返す（bookMoveMutexでロックして呼び出すこと）
+//この探索で既に移動先に引き継いだアーカイブは対象外とする
+func (scan *libraryScan) findMovedBook(fingerprint string) db.BookTable {
+	bookList, err := db.SelectBookListFromFingerprint(scan.ctx, fingerprint)
+	if err != nil {
+		return db.BookTable{}
+	}
+
+	for _, book := range bookList {
+		if scan.movedMap[book.Hash] {
+			continue
+		}
+		_, err := os.Stat(book.FilePath)
+		if os.IsNotExist(err) {
+			fmt.Printf("findMovedBook %s -> moved\n", book.FilePath)
+			return book
+		}
+	}
+	return db.BookTable{}
+}
+
+//isTargetFile は登録対象のアーカイブファイルかどうかを返す
+func isTargetFile(path string) bool {
+	ext := filepath.Ext(path)
+	for _, v := range fileWatcherTargetExt {
+		if v == ext {
+			return true
+		}
+	}
+	return false
+}
+
+//isEquleDateTime は指定したフィル時刻が同一かどうか（分単位まででチェックする）
+func isEquleDateTime(t1 time.Time, t2 time.Time) bool {
+	t1Text := t1.UTC().Format("2006-01-02 15:04")
+	t2Text := t2.UTC().Format("2006-01-02 15:04")
+	if t1Text == t2Text {
+		return true
+	}
+	return false
+}
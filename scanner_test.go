@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mryp/squidgirl-go/config"
+	"github.com/mryp/squidgirl-go/db"
+)
+
+//useSQLiteStore はテスト中だけ一時フォルダのSQLiteをデータ保存先にする（テスト終了時にメモリ上のDBに戻す）
+func useSQLiteStore(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "squidgirl-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := config.GetConfig().DB
+	dbConfig.Driver = db.DriverSQLite
+	dbConfig.FilePath = filepath.Join(dirPath, "squidgirl.db")
+	store, err := db.OpenStore(dbConfig)
+	if err != nil {
+		os.RemoveAll(dirPath)
+		t.Fatal(err)
+	}
+
+	memoryStore := db.GetStore()
+	db.SetStore(store)
+	t.Cleanup(func() {
+		db.SetStore(memoryStore)
+		store.Close()
+		os.RemoveAll(dirPath)
+	})
+	if _, err := db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistFileSQLiteAgeRating(t *testing.T) {
+	useSQLiteStore(t)
+
+	//探索で新しく登録したアーカイブにComicInfo.xmlの対象年齢を保存する
+	ctx := context.Background()
+	NewFileWatcher().RegistFile(ctx, "", true)
+	ratingMap := map[string]int{testOpenBook: 0, testTeenBook: 13, testSecretBook: 0}
+	for filePath, ageRating := range ratingMap {
+		book, err := db.SelectBook(ctx, filePath)
+		if err != nil || book.Hash == "" {
+			t.Fatalf("SelectBook(%s) err=%v", filePath, err)
+		}
+		if book.AgeRating != ageRating {
+			t.Errorf("%s agerating=%d expected=%d", filepath.Base(filePath), book.AgeRating, ageRating)
+		}
+	}
+}
+
+func TestRegistFileRecheckPage(t *testing.T) {
+	//確認し直すアーカイブはページ数を数え直してページ画像キャッシュを削除する
+	ctx := context.Background()
+	book, err := db.SelectBook(ctx, testOpenBook)
+	if err != nil || book.Hash == "" {
+		t.Fatalf("SelectBook err=%v", err)
+	}
+	cacheDirPath := filepath.Join(config.GetConfig().File.PageDirPath, book.Hash)
+	if err := os.MkdirAll(cacheDirPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	book.Status = db.BookStatusUnchecked
+	book.Page = 4
+	if err := db.UpdateBookList(ctx, []db.BookTable{book}); err != nil {
+		t.Fatal(err)
+	}
+
+	NewFileWatcher().RegistFile(ctx, "", true)
+	book, err = db.SelectBook(ctx, testOpenBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Status != db.BookStatusOK || book.Page != 3 {
+		t.Errorf("status=%d page=%d", book.Status, book.Page)
+	}
+	if _, err := os.Stat(cacheDirPath); !os.IsNotExist(err) {
+		t.Errorf("page cache exists err=%v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	scanProgress = NewScanProgress() //ライブラリ探索の進捗状況
+)
+
+//ScanProgress はライブラリ探索の進捗状況を保持する
+type ScanProgress struct {
+	mutex          *sync.Mutex
+	cancel         context.CancelFunc
+	Running        bool
+	Cancelled      bool
+	Path           string //探索対象のフォルダ（空の時はライブラリ全体）
+	Full           bool   //更新日時が変わっていないフォルダも確認するかどうか
+	StartTime      time.Time
+	EndTime        time.Time
+	SeenCount      int //見つかったアーカイブ数
+	QueuedCount    int //確認が必要なアーカイブ数
+	ProcessedCount int //確認が終わったアーカイブ数
+	AddedCount     int
+	UpdatedCount   int
+	MovedCount     int
+	RestoredCount  int //削除済みから復元したアーカイブ数
+	RemovedCount   int
+	ErrorCount     int
+	LastError      string
+}
+
+//NewScanProgress は進捗状況を生成する
+func NewScanProgress() *ScanProgress {
+	progress := new(ScanProgress)
+	progress.mutex = new(sync.Mutex)
+	return progress
+}
+
+//Start は進捗状況をクリアーして探索開始とする（すでに探索中の時はfalseを返す）
+//cancelは探索を中止する時に呼び出す
+func (progress *ScanProgress) Start(path string, full bool, cancel context.CancelFunc) bool {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	if progress.Running {
+		return false
+	}
+	mutex := progress.mutex
+	*progress = ScanProgress{mutex: mutex, cancel: cancel}
+	progress.Running = true
+	progress.Path = path
+	progress.Full = full
+	progress.StartTime = time.Now()
+	return true
+}
+
+//Cancel は探索を中止する（探索中でない時はfalseを返す）
+func (progress *ScanProgress) Cancel() bool {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	if !progress.Running || progress.cancel == nil {
+		return false
+	}
+	progress.cancel()
+	progress.Cancelled = true
+	return true
+}
+
+//Finish は探索終了とする
+func (progress *ScanProgress) Finish() {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.Running = false
+	progress.cancel = nil
+	progress.EndTime = time.Now()
+}
+
+//addSeen は見つかったアーカイブ数と確認が必要なアーカイブ数を加算する
+func (progress *ScanProgress) addSeen(seenCount int, queuedCount int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.SeenCount += seenCount
+	progress.QueuedCount += queuedCount
+}
+
+//addResult はアーカイブ1件分の確認結果を加算する
+func (progress *ScanProgress) addResult(result scanResult, err error) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.ProcessedCount++
+	switch result {
+	case scanResultUpdated:
+		progress.UpdatedCount++
+	case scanResultMoved:
+		progress.MovedCount++
+	case scanResultRestored:
+		progress.RestoredCount++
+	}
+	if err != nil {
+		progress.ErrorCount++
+		progress.LastError = err.Error()
+	}
+}
+
+//addAdded は新規登録したアーカイブ数を加算する
+func (progress *ScanProgress) addAdded(count int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.AddedCount += count
+}
+
+//addRemoved は削除したアーカイブ数を加算する
+func (progress *ScanProgress) addRemoved(count int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.RemovedCount += count
+}
+
+//setError は探索中に発生したエラーを設定する
+func (progress *ScanProgress) setError(err error) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.ErrorCount++
+	progress.LastError = err.Error()
+}
+
+//Status は現在の進捗状況のコピーを返す
+func (progress *ScanProgress) Status() ScanProgress {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	status := *progress
+	status.mutex = nil
+	status.cancel = nil
+	return status
+}
+
+//Duration は探索にかかった時間（探索中の時は開始からの経過時間）を返す
+func (status ScanProgress) Duration() time.Duration {
+	if status.StartTime.IsZero() {
+		return 0
+	}
+	if status.Running {
+		return time.Since(status.StartTime)
+	}
+	return status.EndTime.Sub(status.StartTime)
+}
+
+//EstimateRemaining は確認が終わるまでの残り時間の目安を返す
+//探索中は確認が必要なアーカイブ数が増えていくため、実際より短くなることがある
+func (status ScanProgress) EstimateRemaining() time.Duration {
+	if !status.Running || status.ProcessedCount == 0 || status.QueuedCount <= status.ProcessedCount {
+		return 0
+	}
+	elapsed := time.Since(status.StartTime)
+	return elapsed / time.Duration(status.ProcessedCount) * time.Duration(status.QueuedCount-status.ProcessedCount)
+}
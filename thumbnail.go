@@ -7,7 +7,6 @@ import (
 	"path/filepath"
 
 	"github.com/mryp/squidgirl-go/config"
-	"github.com/mryp/squidgirl-go/db"
 )
 
 //Thumbnail はサムネイル変換情報を保持する
@@ -31,11 +30,6 @@ func (thum *Thumbnail) GetFilePathFromHash(hash string) string {
 	return filepath.Join(thum.dirPath, hash+".jpg")
 }
 
-//GetFilePath はアーカイブのファイルパスからサムネイルのファイルパスを取得する
-func (thum *Thumbnail) GetFilePath(bookPath string) string {
-	return thum.GetFilePathFromHash(db.CreateBookHash(bookPath))
-}
-
 //IsExist は指定したサムネイルファイルが存在するかどうかを返す
 func (thum *Thumbnail) IsExist(filePath string) bool {
 	_, err := os.Stat(filePath)
@@ -46,8 +40,18 @@ func (thum *Thumbnail) IsExist(filePath string) bool {
 	return true
 }
 
+//RemoveFile は指定したアーカイブのサムネイルファイルを削除する（存在しない時は何もしない）
+func (thum *Thumbnail) RemoveFile(hash string) error {
+	err := os.Remove(thum.GetFilePathFromHash(hash))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("サムネイル削除エラー err:%s\n", err)
+		return err
+	}
+	return nil
+}
+
 //CreateFile はアーカイブファイルの先頭ファイル画像をサムネイル画像として保存する
-func (thum *Thumbnail) CreateFile(bookPath string) error {
+func (thum *Thumbnail) CreateFile(hash string, bookPath string) error {
 	//ZIPファイルを開く
 	r, err := zip.OpenReader(bookPath)
 	if err != nil {
@@ -56,7 +60,7 @@ func (thum *Thumbnail) CreateFile(bookPath string) error {
 	}
 	defer r.Close()
 
-	for _, f := range r.File {
+	for _, f := range pageFileList(&r.Reader) {
 		//ZIPファイル内のファイルを開く
 		rc, err := f.Open()
 		if err != nil {
@@ -65,12 +69,10 @@ func (thum *Thumbnail) CreateFile(bookPath string) error {
 		}
 		defer rc.Close()
 
-		if !f.FileInfo().IsDir() {
-			//最初のページファイルをサムネイル画像として作成する
-			resize := NewResize(0, thum.width, thum.jpegQuality)
-			resize.ResizeFile(rc, thum.GetFilePath(bookPath))
-			break
-		}
+		//最初のページファイルをサムネイル画像として作成する
+		resize := NewResize(0, thum.width, thum.jpegQuality)
+		resize.ResizeFile(rc, thum.GetFilePathFromHash(hash))
+		break
 	}
 
 	return nil
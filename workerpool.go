@@ -0,0 +1,52 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+//workerPool は指定した数のゴルーチンで処理を並列に実行する
+type workerPool struct {
+	jobs chan func()
+	wg   *sync.WaitGroup
+}
+
+//newWorkerPool は指定した数のワーカーを起動して返す（0以下の時はCPU数とする）
+func newWorkerPool(count int) *workerPool {
+	if count <= 0 {
+		count = runtime.NumCPU()
+	}
+
+	pool := new(workerPool)
+	pool.jobs = make(chan func(), count*2)
+	pool.wg = new(sync.WaitGroup)
+	for i := 0; i < count; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+//work は処理を受け取って実行する
+func (pool *workerPool) work() {
+	for job := range pool.jobs {
+		job()
+		pool.wg.Done()
+	}
+}
+
+//Submit は処理を追加する（ワーカーがすべて処理中の時は空くまで待つ）
+//ワーカー内の処理から呼び出すと待ち合わせできなくなるため、ワーカー外から呼び出すこと
+func (pool *workerPool) Submit(job func()) {
+	pool.wg.Add(1)
+	pool.jobs <- job
+}
+
+//Wait は追加した処理がすべて終わるまで待つ
+func (pool *workerPool) Wait() {
+	pool.wg.Wait()
+}
+
+//Close はワーカーを終了する（追加した処理が終わってから呼び出すこと）
+func (pool *workerPool) Close() {
+	close(pool.jobs)
+}